@@ -45,7 +45,7 @@ func (a *AtomicBoolean) CompareAndSet(expect, update bool) bool {
 	if update {
 		n = 1
 	} else {
-		o = 0
+		n = 0
 	}
 
 	return atomic.CompareAndSwapInt32((*int32)(a), o, n)
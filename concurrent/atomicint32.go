@@ -12,7 +12,7 @@ func NewAtomicInt32(val int32) *AtomicInt32 {
 
 // 得到该值
 func (a *AtomicInt32) Get() int32 {
-	return int32(*a)
+	return atomic.LoadInt32((*int32)(a))
 }
 
 // 将值设置进去
@@ -12,7 +12,7 @@ func NewAtomicInt64(val int64) *AtomicInt64 {
 
 // 得到该值
 func (a *AtomicInt64) Get() int64 {
-	return int64(*a)
+	return atomic.LoadInt64((*int64)(a))
 }
 
 // 将值设置进去
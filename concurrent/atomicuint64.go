@@ -14,7 +14,7 @@ func NewAtomicUint64(val uint64) *AtomicUint64 {
 
 // 得到该值
 func (a *AtomicUint64) Get() uint64 {
-	return uint64(*a)
+	return atomic.LoadUint64((*uint64)(a))
 }
 
 // 将值设置进去
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 )
 
 const ConcurrentMapNum = 32
@@ -57,43 +58,66 @@ func (g *ConcurrentIDGroupMap) Dispose() {
 
 			syncIDMap.Unlock()
 		}
-		g.count = 0
+		atomic.StoreInt32(&g.count, 0)
 		// 执行阻塞，直到所有都释放了
 		g.disposeWait.Wait()
 	})
 }
 
+// 取的是指针，复制的话锁就没有作用了
 func (g *ConcurrentIDGroupMap) Get(id uint64) interface{} {
-	syncIDMap := g.SyncMaps[id%ConcurrentMapNum]
-	syncIDMap.Lock()
-	defer syncIDMap.Unlock()
+	syncIDMap := &g.SyncMaps[id%ConcurrentMapNum]
+	syncIDMap.RLock()
+	defer syncIDMap.RUnlock()
 	item, _ := syncIDMap.Items[id]
 	return item
 }
 
 func (g *ConcurrentIDGroupMap) Set(id uint64, item interface{}) {
-	syncIDMap := g.SyncMaps[id%ConcurrentMapNum]
+	syncIDMap := &g.SyncMaps[id%ConcurrentMapNum]
 	syncIDMap.Lock()
 	defer syncIDMap.Unlock()
+	if _, ok := syncIDMap.Items[id]; !ok {
+		// 覆盖的时候不重复计数
+		g.disposeWait.Add(1)
+		atomic.AddInt32(&g.count, 1)
+	}
 	syncIDMap.Items[id] = item
-	g.disposeWait.Add(1)
-	g.count++
 }
 
 func (g *ConcurrentIDGroupMap) Del(id uint64) {
 	if g.disposeFlag {
-		g.disposeWait.Done()
 		return
 	}
-	syncIDMap := g.SyncMaps[id%ConcurrentMapNum]
+	syncIDMap := &g.SyncMaps[id%ConcurrentMapNum]
 	syncIDMap.Lock()
 	defer syncIDMap.Unlock()
+	if _, ok := syncIDMap.Items[id]; !ok {
+		// 不存在的时候不能减少计数，否则WaitGroup会变成负数
+		return
+	}
 	delete(syncIDMap.Items, id)
 
 	g.disposeWait.Done()
-	g.count--
+	atomic.AddInt32(&g.count, -1)
+}
+
+// 遍历，fn返回false的时候停止
+// 遍历的时候持有分片的读锁，fn中不要对同一个map执行写操作
+func (g *ConcurrentIDGroupMap) Range(fn func(id uint64, item interface{}) bool) {
+	for i := 0; i < ConcurrentMapNum; i++ {
+		syncIDMap := &g.SyncMaps[i]
+		syncIDMap.RLock()
+		for id, item := range syncIDMap.Items {
+			if !fn(id, item) {
+				syncIDMap.RUnlock()
+				return
+			}
+		}
+		syncIDMap.RUnlock()
+	}
 }
 
 func (g *ConcurrentIDGroupMap) Count() int32 {
-	return g.count
+	return atomic.LoadInt32(&g.count)
 }
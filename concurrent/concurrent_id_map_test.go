@@ -3,6 +3,7 @@ package concurrent
 import "testing"
 import "fmt"
 import "time"
+import "sync"
 
 type T1 struct {
 }
@@ -28,3 +29,75 @@ func TestConcurrentMap(t *testing.T) {
 	ma.Set(40, obj4)
 	ma.Dispose()
 }
+
+func TestConcurrentIDMapCount(t *testing.T) {
+	ma := NewCocurrentIDGroup()
+	ma.Set(1, &T1{})
+	// 覆盖不重复计数，删除不存在的不减少计数
+	ma.Set(1, &T1{})
+	ma.Del(2)
+	if n := ma.Count(); n != 1 {
+		t.Fatalf("expected 1, got %d", n)
+	}
+	ma.Del(1)
+	ma.Del(1)
+	if n := ma.Count(); n != 0 || ma.Get(1) != nil {
+		t.Fatalf("expected empty, got %d", n)
+	}
+	// 计数正确的时候，释放不会卡住
+	ma.Set(3, &T1{})
+	ma.Dispose()
+}
+
+func TestConcurrentIDMapParallel(t *testing.T) {
+	ma := NewCocurrentIDGroup()
+	// 同一个分片上并发读写，锁要作用在map自己的分片上
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				id := uint64((i*100 + j) * ConcurrentMapNum)
+				ma.Set(id, j)
+				if ma.Get(id) != j {
+					t.Errorf("id %d not found", id)
+				}
+				if j%2 == 0 {
+					ma.Del(id)
+				}
+			}
+		}(i)
+	}
+	wait.Wait()
+	if n := ma.Count(); n != 400 {
+		t.Fatalf("expected 400, got %d", n)
+	}
+}
+
+func TestConcurrentIDMapRange(t *testing.T) {
+	ma := NewCocurrentIDGroup()
+	for i := uint64(0); i < 10; i++ {
+		ma.Set(i, i)
+	}
+	seen := make(map[uint64]bool)
+	ma.Range(func(id uint64, item interface{}) bool {
+		if item.(uint64) != id {
+			t.Fatalf("id %d has item %v", id, item)
+		}
+		seen[id] = true
+		return true
+	})
+	if len(seen) != 10 {
+		t.Fatalf("expected 10, got %d", len(seen))
+	}
+	// 返回false的时候停止
+	count := 0
+	ma.Range(func(id uint64, item interface{}) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Fatalf("expected stop at 3, got %d", count)
+	}
+}
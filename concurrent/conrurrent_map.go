@@ -60,7 +60,7 @@ func (m *ConcurrentMap) Set(key, value interface{}) {
 
 	if value == nil {
 
-		fmt.Printf("err :concurrent map :set map nil value key:%v")
+		fmt.Printf("err :concurrent map :set map nil value key:%v\n", key)
 		return
 	}
 
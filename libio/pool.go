@@ -0,0 +1,72 @@
+package libio
+
+import "sync"
+
+// 按大小分级的缓冲池，每一级的大小是上一级的两倍
+// 超过最大一级的缓冲直接分配，放回的时候丢弃
+type Pool struct {
+	minSize int
+	maxSize int
+	classes []sync.Pool
+}
+
+// 新建缓冲池，minSize和maxSize会向上取到2的幂
+func NewPool(minSize, maxSize int) *Pool {
+	if minSize < 1 {
+		minSize = 1
+	}
+	p := new(Pool)
+	p.minSize = roundPow2(minSize)
+	p.maxSize = roundPow2(maxSize)
+	if p.maxSize < p.minSize {
+		p.maxSize = p.minSize
+	}
+	for size := p.minSize; size <= p.maxSize; size <<= 1 {
+		p.classes = append(p.classes, sync.Pool{})
+	}
+	return p
+}
+
+func roundPow2(n int) int {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	return size
+}
+
+// 级别的下标，没有合适的级别返回-1
+func (p *Pool) class(size int) int {
+	if size > p.maxSize {
+		return -1
+	}
+	i, classSize := 0, p.minSize
+	for classSize < size {
+		classSize <<= 1
+		i++
+	}
+	return i
+}
+
+// 取一个长度为size的缓冲，内容没有清零
+func (p *Pool) Get(size int) []byte {
+	i := p.class(size)
+	if i < 0 {
+		return make([]byte, size)
+	}
+	if b, ok := p.classes[i].Get().([]byte); ok {
+		return b[:size]
+	}
+	return make([]byte, size, p.minSize<<uint(i))
+}
+
+// 放回缓冲，放回之后不能再使用
+// 容量不是某一级大小的缓冲不是从池中取出的，直接丢弃
+func (p *Pool) Put(b []byte) {
+	size := cap(b)
+	i := p.class(size)
+	if i < 0 || p.minSize<<uint(i) != size {
+		return
+	}
+	p.classes[i].Put(b[:size])
+}
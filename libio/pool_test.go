@@ -0,0 +1,42 @@
+package libio
+
+import "testing"
+
+func TestPoolClasses(t *testing.T) {
+	p := NewPool(60, 1000)
+	if p.minSize != 64 || p.maxSize != 1024 || len(p.classes) != 5 {
+		t.Fatalf("unexpected pool %d %d %d", p.minSize, p.maxSize, len(p.classes))
+	}
+	for _, c := range []struct {
+		size, cap int
+	}{{1, 64}, {64, 64}, {65, 128}, {1000, 1024}, {1024, 1024}, {1025, 1025}} {
+		b := p.Get(c.size)
+		if len(b) != c.size || cap(b) != c.cap {
+			t.Fatalf("get %d: expected cap %d, got %d %d", c.size, c.cap, len(b), cap(b))
+		}
+	}
+}
+
+func TestPoolPut(t *testing.T) {
+	p := NewPool(64, 1024)
+	// 放回的缓冲按容量回到对应的级别，再取的时候长度按需要的来
+	b := p.Get(100)
+	b[0] = 1
+	p.Put(b)
+	for i := 0; i < 100; i++ {
+		c := p.Get(70)
+		if len(c) != 70 || cap(c) != 128 {
+			t.Fatalf("expected 70/128, got %d/%d", len(c), cap(c))
+		}
+		p.Put(c)
+	}
+
+	// 容量不是某一级大小的不是池中取出的，直接丢弃
+	p.Put(make([]byte, 100))
+	p.Put(make([]byte, 2048))
+	for i := 0; i < 100; i++ {
+		if c := p.Get(100); cap(c) != 128 {
+			t.Fatalf("foreign buffer reused, cap %d", cap(c))
+		}
+	}
+}
@@ -7,9 +7,10 @@ import (
 var zero [MaxVarintLen64]byte
 
 type Reader struct {
-	R   io.Reader
-	buf [MaxVarintLen64]byte
-	err error
+	R    io.Reader
+	buf  [MaxVarintLen64]byte
+	err  error
+	pool *Pool
 }
 
 func NewReader(r io.Reader) *Reader {
@@ -23,6 +24,11 @@ func (reader *Reader) Reset(r io.Reader) {
 	reader.err = nil
 }
 
+// 设置之后 ReadBytes 从缓冲池中取，用完之后由使用者放回
+func (r *Reader) SetPool(pool *Pool) {
+	r.pool = pool
+}
+
 func (r *Reader) Error() error {
 	return r.err
 }
@@ -36,7 +42,11 @@ func (r *Reader) Read(b []byte) (n int, err error) {
 }
 
 func (r *Reader) ReadBytes(n int) (b []byte) {
-	b = make([]byte, n)
+	if r.pool != nil {
+		b = r.pool.Get(n)
+	} else {
+		b = make([]byte, n)
+	}
 	_, r.err = io.ReadFull(r, b)
 	return b
 }
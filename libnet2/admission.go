@@ -0,0 +1,235 @@
+package libnet2
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 连接的准入检查，在 Accept 之后、建立session之前执行
+// 多个检查按顺序执行，有一个拒绝的时候连接直接关闭
+type AcceptFilter interface {
+	// 返回错误的时候拒绝连接
+	Allow(conn net.Conn) error
+	// 通过检查的连接关闭的时候调用，释放占用的名额
+	Release(conn net.Conn)
+}
+
+// 被拒绝的连接，通过 Handler.OnError 通知
+type RejectError struct {
+	Addr net.Addr
+	Err  error
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("reject conn from %v: %v", e.Addr, e.Err)
+}
+
+// 连接对端的ip
+func remoteIP(conn net.Conn) net.IP {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return nil
+	}
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+func remoteIPKey(conn net.Conn) string {
+	if ip := remoteIP(conn); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// 令牌桶，每秒补充rate个，最多存burst个
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// 新建令牌桶，开始的时候是满的，burst小于1的时候为1
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	b := new(TokenBucket)
+	if burst < 1 {
+		burst = 1
+	}
+	b.rate = rate
+	b.burst = float64(burst)
+	b.tokens = b.burst
+	b.last = time.Now()
+	return b
+}
+
+// 取一个令牌，没有的时候返回false
+func (b *TokenBucket) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 并发连接数的限制，total和perIP小于等于0的时候不限制
+type ConnLimiter struct {
+	mutex    sync.Mutex
+	max      int
+	maxPerIP int
+	total    int
+	perIP    map[string]int
+}
+
+func NewConnLimiter(max, maxPerIP int) *ConnLimiter {
+	l := new(ConnLimiter)
+	l.max = max
+	l.maxPerIP = maxPerIP
+	l.perIP = make(map[string]int)
+	return l
+}
+
+func (l *ConnLimiter) Allow(conn net.Conn) error {
+	key := remoteIPKey(conn)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		return ErrConnLimit
+	}
+	if l.maxPerIP > 0 && l.perIP[key] >= l.maxPerIP {
+		return ErrIPConnLimit
+	}
+	l.total++
+	l.perIP[key]++
+	return nil
+}
+
+func (l *ConnLimiter) Release(conn net.Conn) {
+	key := remoteIPKey(conn)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.total--
+	if l.perIP[key]--; l.perIP[key] <= 0 {
+		delete(l.perIP, key)
+	}
+}
+
+// 当前的连接数
+func (l *ConnLimiter) Count() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.total
+}
+
+// 建立连接的速率限制，超过的连接直接拒绝
+type RateFilter struct {
+	bucket *TokenBucket
+}
+
+// rate为每秒允许建立的连接数，burst为允许的突发数
+func NewRateFilter(rate float64, burst int) *RateFilter {
+	f := new(RateFilter)
+	f.bucket = NewTokenBucket(rate, burst)
+	return f
+}
+
+func (f *RateFilter) Allow(conn net.Conn) error {
+	if !f.bucket.Allow() {
+		return ErrAcceptRate
+	}
+	return nil
+}
+
+func (f *RateFilter) Release(conn net.Conn) {
+}
+
+// ip的黑白名单，黑名单优先，白名单不为空的时候只允许白名单中的ip
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// 新建黑白名单，格式为 10.0.0.0/8 或者单个ip
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	f := new(IPFilter)
+	var err error
+	if f.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// 是否允许这个ip
+func (f *IPFilter) Contains(ip net.IP) bool {
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *IPFilter) Allow(conn net.Conn) error {
+	ip := remoteIP(conn)
+	if ip == nil || !f.Contains(ip) {
+		return ErrIPDenied
+	}
+	return nil
+}
+
+func (f *IPFilter) Release(conn net.Conn) {
+}
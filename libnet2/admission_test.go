@@ -0,0 +1,67 @@
+package libnet2
+
+import (
+	"net"
+	"testing"
+)
+
+type addrTestConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *addrTestConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func testConn(ip string) net.Conn {
+	return &addrTestConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+}
+
+func TestIPFilter(t *testing.T) {
+	filter, err := NewIPFilter([]string{"10.0.0.0/8", "192.168.1.1"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]error{
+		"10.2.3.4":    nil,
+		"10.1.2.3":    ErrIPDenied,
+		"192.168.1.1": nil,
+		"192.168.1.2": ErrIPDenied,
+	}
+	for ip, want := range cases {
+		if err := filter.Allow(testConn(ip)); err != want {
+			t.Fatalf("%s expected %v, got %v", ip, want, err)
+		}
+	}
+	if _, err := NewIPFilter([]string{"bad"}, nil); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	limiter := NewConnLimiter(3, 2)
+	a, b := testConn("1.1.1.1"), testConn("2.2.2.2")
+	for _, conn := range []net.Conn{a, a, b} {
+		if err := limiter.Allow(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := limiter.Allow(b); err != ErrConnLimit {
+		t.Fatalf("expected conn limit, got %v", err)
+	}
+	limiter.Release(b)
+	if err := limiter.Allow(a); err != ErrIPConnLimit {
+		t.Fatalf("expected ip conn limit, got %v", err)
+	}
+	if err := limiter.Allow(b); err != nil || limiter.Count() != 3 {
+		t.Fatalf("expected released slot, got %v count %d", err, limiter.Count())
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(0, 2)
+	if !bucket.Allow() || !bucket.Allow() || bucket.Allow() {
+		t.Fatal("expected burst of 2")
+	}
+}
@@ -1,10 +1,13 @@
 package libnet2
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 )
 
+// 全局的回调，只在服务没有设置Handler对应字段的时候使用
+// 多个服务共用同一份，新代码请使用Handler
 var (
 	// 服务收到错误的回调
 	ServerErrorBlock OnError
@@ -31,21 +34,101 @@ func New() (LibserverInterface, error) {
 }
 
 // 用配置新建一个服务
-func NewWithOption(option *NetOption, sessionOption *SessionOption2) (LibserverInterface, error) {
+// handler 可以不传，不传的时候使用全局的回调
+func NewWithOption(option *NetOption, sessionOption *SessionOption2, handler ...*Handler) (LibserverInterface, error) {
+	if option.ReactorLoops > 0 && (option.TLSConfig != nil || option.Network == NetworkWebSocket || isUDPNetwork(option.Network)) {
+		// reactor模式直接读写fd，只支持tcp
+		return nil, ErrReactorUnsupported
+	}
 
-	listener, err := net.Listen(option.Network, option.Address)
+	listener, socket, err := listen(option)
 
 	if err != nil {
 		return nil, err
 	}
+	server, err := newServerWithListener(listener, option, sessionOption, handler...)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	server.socket = socket
+	return server, nil
+}
+
+// 在已有的监听上新建服务，例如测试中的内存监听
+// option 中的 Network 和 Address 不再使用，TLS 和 PROXY protocol 需要调用者自己包装
+// reactor模式只能用于有fd的tcp监听
+func NewWithListener(listener net.Listener, option *NetOption, sessionOption *SessionOption2, handler ...*Handler) (LibserverInterface, error) {
+	server, err := newServerWithListener(listener, option, sessionOption, handler...)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func newServerWithListener(listener net.Listener, option *NetOption, sessionOption *SessionOption2, handler ...*Handler) (*defaultLibServer, error) {
 	server := newServer()
 	server.listener = listener
 	server.serverOption = option
 	server.sessionOption = sessionOption
+	if option.MaxConn > 0 {
+		server.connSlots = make(chan bool, option.MaxConn)
+	}
+	if option.ReactorLoops > 0 {
+		var err error
+		if server.reactor, err = newReactor(option.ReactorLoops); err != nil {
+			return nil, err
+		}
+	}
+	if len(handler) > 0 {
+		server.handler = handler[0]
+	}
 	return server, nil
 }
 
-// 默认的session服务
+// 按照网络类型监听，热重启启动的进程使用父进程交过来的socket
+// socket 是最下层的监听，热重启的时候交给新的进程
+func listen(option *NetOption) (net.Listener, *inheritSocket, error) {
+	if isUDPNetwork(option.Network) {
+		pc, socket, err := listenPacket(option.Network, option.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newUDPListener(pc, option.ARQ), socket, nil
+	}
+	network := option.Network
+	if network == NetworkWebSocket {
+		network = "tcp"
+	}
+	listener, socket, err := listenStream(network, option.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if option.ProxyProtocol {
+		// 包头在tls握手之前
+		listener = newProxyListener(listener, option.ProxyHeaderTimeout, option.MaxConn)
+	}
+	if option.TLSConfig != nil {
+		listener = tls.NewListener(listener, option.TLSConfig)
+	}
+	if option.Network == NetworkWebSocket {
+		// websocket的tls由http服务处理
+		listener = newWSListener(listener, option)
+	}
+	return listener, socket, nil
+}
+
+// 默认的可靠udp配置
+func DefaultARQOption() *ARQOption {
+	option := new(ARQOption)
+	// 200ms重传一次，最多10次
+	option.RetransmitInterval = time.Millisecond * time.Duration(200)
+	option.RetransmitTimes = 10
+	option.Window = 128
+	return option
+}
+
+// 默认的服务session
 func DefaultSessionOption() *SessionOption2 {
 	option := new(SessionOption2)
 	// 60s
@@ -68,10 +151,33 @@ func DefaultOption() *NetOption {
 	option.MaxConn = -1
 	option.Network = "tcp"
 	option.Workers = 4
+	option.TLSHandshakeTimeout = time.Second * time.Duration(10)
+	option.ProxyHeaderTimeout = time.Second * time.Duration(5)
+	option.WebSocketHandshakeTimeout = time.Second * time.Duration(10)
+	return option
+}
+
+// 新建默认的客户端配置
+func DefaultClientOption() *ClientOption {
+	option := new(ClientOption)
+	// 默认连接本机10001
+	option.Address = "127.0.0.1:10001"
+	option.Network = "tcp"
+	option.DialTimeout = time.Second * time.Duration(5)
+	option.Reconnect = true
+	// 1s开始，每次翻倍，最多30s
+	option.ReconnectDelay = time.Second
+	option.ReconnectFactor = 2
+	option.ReconnectMaxDelay = time.Second * time.Duration(30)
 	return option
 }
 
 // 默认的服务session
-func DefaultSession(conn net.Conn, sessionOption *SessionOption2) Session2Interface {
-	return newDefaultSession(conn, sessionOption)
+// handler 可以不传，不传的时候使用全局的回调
+func DefaultSession(conn net.Conn, sessionOption *SessionOption2, handler ...*Handler) Session2Interface {
+	var h *Handler
+	if len(handler) > 0 {
+		h = handler[0]
+	}
+	return newDefaultSession(conn, sessionOption, h)
 }
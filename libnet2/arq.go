@@ -0,0 +1,296 @@
+package libnet2
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 可靠udp每个包的包头，1个字节的类型，4个字节大端的序号
+const (
+	arqCmdData byte = 1
+	arqCmdAck  byte = 2
+	arqCmdFin  byte = 3
+
+	arqHeadSize = 5
+)
+
+// 按消息收发的连接，可靠udp的下层
+type datagramConn interface {
+	net.Conn
+	MessageConn
+}
+
+type arqSegment struct {
+	cmd      byte
+	seq      uint32
+	val      []byte
+	sendTime time.Time
+	times    int
+}
+
+func (seg *arqSegment) encode() []byte {
+	val := make([]byte, arqHeadSize+len(seg.val))
+	val[0] = seg.cmd
+	libio.PutUint32BE(val[1:arqHeadSize], seg.seq)
+	copy(val[arqHeadSize:], seg.val)
+	return val
+}
+
+// 可靠有序的udp连接
+// 每个包都有序号，收到之后回复确认，没有确认的包定时重传，超过次数之后连接失败
+// 收到的包按照序号排序之后再交给上层，一次Write就是一个包
+type arqConn struct {
+	conn   datagramConn
+	option *ARQOption
+
+	mutex sync.Mutex
+	// 发送窗口满的时候等待
+	cond    *sync.Cond
+	sndNext uint32
+	unacked map[uint32]*arqSegment
+	rcvNext uint32
+	rcvBuf  map[uint32]*arqSegment
+	// 重传失败的错误
+	err error
+
+	recvChan chan []byte
+	stream   messageStream
+	deadline *readDeadline
+	// 对端关闭
+	finChan chan bool
+
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+func newARQConn(conn datagramConn, option *ARQOption) *arqConn {
+	c := new(arqConn)
+	c.conn = conn
+	c.option = option
+	c.check()
+	c.cond = sync.NewCond(&c.mutex)
+	c.unacked = make(map[uint32]*arqSegment)
+	c.rcvBuf = make(map[uint32]*arqSegment)
+	c.recvChan = make(chan []byte, c.option.Window)
+	c.deadline = newReadDeadline()
+	c.finChan = make(chan bool)
+	c.closeChan = make(chan bool)
+	go c.readLoop()
+	go c.retransmitLoop()
+	return c
+}
+
+func (c *arqConn) check() {
+	if c.option.RetransmitInterval <= 0 {
+		c.option.RetransmitInterval = time.Duration(200) * time.Millisecond
+	}
+	if c.option.RetransmitTimes <= 0 {
+		c.option.RetransmitTimes = 10
+	}
+	if c.option.Window <= 0 {
+		c.option.Window = 128
+	}
+}
+
+// 对端关闭的时候只退出，由上层读到io.EOF之后关闭
+func (c *arqConn) readLoop() {
+	for {
+		val, err := c.conn.ReadMessage()
+		if err != nil {
+			c.Close()
+			return
+		}
+		if len(val) < arqHeadSize {
+			continue
+		}
+		seg := &arqSegment{cmd: val[0], seq: libio.GetUint32BE(val[1:arqHeadSize]), val: val[arqHeadSize:]}
+		switch seg.cmd {
+		case arqCmdAck:
+			c.mutex.Lock()
+			delete(c.unacked, seg.seq)
+			c.cond.Broadcast()
+			c.mutex.Unlock()
+		case arqCmdData, arqCmdFin:
+			if !c.deliver(seg) {
+				return
+			}
+		}
+	}
+}
+
+// 回复确认，并按照序号交给上层，返回false的时候连接已经结束
+// 接收窗口包括还没被上层读走的包，窗口内的包放进 recvChan 一定不会阻塞
+// 上层读得慢的时候只是不再确认新的包，不会卡住读取的协程，对端的确认照常处理
+func (c *arqConn) deliver(seg *arqSegment) bool {
+	c.mutex.Lock()
+	offset := int32(seg.seq - c.rcvNext)
+	if offset >= int32(c.option.Window-len(c.recvChan)) {
+		// 超过接收窗口的包不确认，等待重传
+		c.mutex.Unlock()
+		return true
+	}
+	ack := &arqSegment{cmd: arqCmdAck, seq: seg.seq}
+	if offset < 0 {
+		// 重复的包，之前的确认丢失了，再确认一次
+		c.mutex.Unlock()
+		c.conn.WriteMessage(ack.encode())
+		return true
+	}
+	c.rcvBuf[seg.seq] = seg
+	ready := make([]*arqSegment, 0, 1)
+	for {
+		next, ok := c.rcvBuf[c.rcvNext]
+		if !ok {
+			break
+		}
+		delete(c.rcvBuf, c.rcvNext)
+		c.rcvNext++
+		ready = append(ready, next)
+	}
+	c.mutex.Unlock()
+	c.conn.WriteMessage(ack.encode())
+
+	for _, next := range ready {
+		if next.cmd == arqCmdFin {
+			close(c.finChan)
+			return false
+		}
+		c.recvChan <- next.val
+	}
+	return true
+}
+
+func (c *arqConn) retransmitLoop() {
+	ticker := time.NewTicker(c.option.RetransmitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeChan:
+			return
+		case now := <-ticker.C:
+			resend := make([]*arqSegment, 0)
+			c.mutex.Lock()
+			for _, seg := range c.unacked {
+				if now.Sub(seg.sendTime) < c.option.RetransmitInterval {
+					continue
+				}
+				if seg.times >= c.option.RetransmitTimes {
+					c.err = errARQTimeout
+					break
+				}
+				seg.times++
+				seg.sendTime = now
+				resend = append(resend, seg)
+			}
+			failed := c.err != nil
+			c.mutex.Unlock()
+
+			if failed {
+				c.Close()
+				return
+			}
+			for _, seg := range resend {
+				c.conn.WriteMessage(seg.encode())
+			}
+		}
+	}
+}
+
+func (c *arqConn) ReadMessage() ([]byte, error) {
+	// 先取已经收到的，保证对端关闭之前的数据都能读到
+	select {
+	case val := <-c.recvChan:
+		return val, nil
+	default:
+	}
+	select {
+	case val := <-c.recvChan:
+		return val, nil
+	case <-c.finChan:
+		return nil, io.EOF
+	case <-c.closeChan:
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, errClosed
+	case <-c.deadline.wait():
+		return nil, errTimeout
+	}
+}
+
+// 发送窗口满的时候阻塞，直到收到确认
+func (c *arqConn) WriteMessage(b []byte) error {
+	c.mutex.Lock()
+	for len(c.unacked) >= c.option.Window && !isChanClosed(c.closeChan) {
+		c.cond.Wait()
+	}
+	if isChanClosed(c.closeChan) {
+		c.mutex.Unlock()
+		return errClosed
+	}
+	seg := &arqSegment{cmd: arqCmdData, seq: c.sndNext, sendTime: time.Now()}
+	seg.val = make([]byte, len(b))
+	copy(seg.val, b)
+	c.sndNext++
+	c.unacked[seg.seq] = seg
+	c.mutex.Unlock()
+	return c.conn.WriteMessage(seg.encode())
+}
+
+func (c *arqConn) Read(b []byte) (int, error) {
+	return c.stream.read(b, c.ReadMessage)
+}
+
+func (c *arqConn) Write(b []byte) (int, error) {
+	if err := c.WriteMessage(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 通知对端关闭，关闭的包不重传，丢失的时候对端依靠超时释放
+func (c *arqConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		fin := &arqSegment{cmd: arqCmdFin, seq: c.sndNext}
+		c.sndNext++
+		close(c.closeChan)
+		c.cond.Broadcast()
+		c.mutex.Unlock()
+
+		if !isChanClosed(c.finChan) {
+			c.conn.WriteMessage(fin.encode())
+		}
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *arqConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *arqConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *arqConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *arqConn) SetReadDeadline(t time.Time) error {
+	c.deadline.set(t)
+	return nil
+}
+
+// 写入只会在发送窗口满的时候阻塞，由重传的次数限制
+func (c *arqConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
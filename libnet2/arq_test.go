@@ -0,0 +1,134 @@
+package libnet2
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 内存中的udp，按照概率丢包和乱序
+type lossyConn struct {
+	net.Conn
+	recvChan chan []byte
+	peer     *lossyConn
+	loss     float64
+
+	mutex  sync.Mutex
+	random *rand.Rand
+
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+func newLossyPair(loss float64) (*lossyConn, *lossyConn) {
+	a := &lossyConn{recvChan: make(chan []byte, 1024), loss: loss, random: rand.New(rand.NewSource(1)), closeChan: make(chan bool)}
+	b := &lossyConn{recvChan: make(chan []byte, 1024), loss: loss, random: rand.New(rand.NewSource(2)), closeChan: make(chan bool)}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (c *lossyConn) ReadMessage() ([]byte, error) {
+	select {
+	case val := <-c.recvChan:
+		return val, nil
+	case <-c.closeChan:
+		return nil, errClosed
+	}
+}
+
+func (c *lossyConn) WriteMessage(b []byte) error {
+	c.mutex.Lock()
+	drop := c.random.Float64() < c.loss
+	delay := time.Duration(c.random.Intn(3)) * time.Millisecond
+	c.mutex.Unlock()
+	if drop {
+		return nil
+	}
+	val := append([]byte(nil), b...)
+	time.AfterFunc(delay, func() {
+		select {
+		case c.peer.recvChan <- val:
+		case <-c.peer.closeChan:
+		}
+	})
+	return nil
+}
+
+func (c *lossyConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	return nil
+}
+
+func TestARQOrderedDelivery(t *testing.T) {
+	a, b := newLossyPair(0.3)
+	option := &ARQOption{RetransmitInterval: 5 * time.Millisecond, RetransmitTimes: 50, Window: 16}
+	sender := newARQConn(a, option)
+	receiver := newARQConn(b, option)
+
+	const count = 200
+	go func() {
+		for i := 0; i < count; i++ {
+			sender.WriteMessage([]byte(fmt.Sprintf("msg-%d", i)))
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+		val, err := receiver.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %d error %v", i, err)
+		}
+		if want := fmt.Sprintf("msg-%d", i); string(val) != want {
+			t.Fatalf("expected %s, got %s", want, val)
+		}
+	}
+
+	sender.Close()
+	receiver.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := receiver.ReadMessage(); err != io.EOF && err != errTimeout {
+		// 关闭的包不重传，丢失的时候只能超时
+		t.Fatalf("expected eof or timeout, got %v", err)
+	}
+	receiver.Close()
+}
+
+// 上层不读的时候，读取的协程照常处理对端的确认，自己发出的包不会超时
+func TestARQSlowReader(t *testing.T) {
+	a, b := newLossyPair(0)
+	patient := &ARQOption{RetransmitInterval: 5 * time.Millisecond, RetransmitTimes: 1000, Window: 4}
+	sender := newARQConn(a, patient)
+	defer sender.Close()
+	slow := newARQConn(b, &ARQOption{RetransmitInterval: 5 * time.Millisecond, RetransmitTimes: 5, Window: 4})
+	defer slow.Close()
+
+	// 超过窗口的包等待上层读走之后再确认
+	const count = 10
+	go func() {
+		for i := 0; i < count; i++ {
+			sender.WriteMessage([]byte(fmt.Sprintf("in-%d", i)))
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		if err := slow.WriteMessage([]byte(fmt.Sprintf("out-%d", i))); err != nil {
+			t.Fatalf("write %d error %v", i, err)
+		}
+		sender.SetReadDeadline(time.Now().Add(time.Second))
+		if val, err := sender.ReadMessage(); err != nil || string(val) != fmt.Sprintf("out-%d", i) {
+			t.Fatalf("read %d got %q %v", i, val, err)
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		slow.SetReadDeadline(time.Now().Add(time.Second))
+		val, err := slow.ReadMessage()
+		if err != nil || string(val) != fmt.Sprintf("in-%d", i) {
+			t.Fatalf("read %d got %q %v", i, val, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package libnet2
+
+import (
+	"net"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 没有设置 SessionOption2.FramePool 的时候，批量写入使用的缓冲池
+var writePool = libio.NewPool(64, 64*1024)
+
+// 收集一批包的写入，一次writev写出
+// 实现了 FrameEncoder 的包直接编码到池中的缓冲里
+// 其他的 PacketInterface 写入的内容会复制到池中的缓冲里，包的实现可以复用自己的缓冲
+type batchWriter struct {
+	pool   *libio.Pool
+	bufs   net.Buffers
+	pooled [][]byte
+}
+
+func newBatchWriter(pool *libio.Pool) *batchWriter {
+	w := new(batchWriter)
+	w.pool = pool
+	return w
+}
+
+func (w *batchWriter) Write(b []byte) (int, error) {
+	buf := w.pool.Get(len(b))
+	copy(buf, b)
+	w.bufs = append(w.bufs, buf)
+	w.pooled = append(w.pooled, buf)
+	return len(b), nil
+}
+
+// 编码一个包，out 是写到这里的 libio.Writer，包没有实现 FrameEncoder 的时候使用
+func (w *batchWriter) encode(packet PacketInterface, out *libio.Writer, b []byte) error {
+	encoder, ok := packet.(FrameEncoder)
+	if !ok {
+		return packet.Write(out, b)
+	}
+	buf := w.pool.Get(encoder.FrameLen(len(b)))
+	n, err := encoder.EncodeFrame(buf, b)
+	if err != nil {
+		w.pool.Put(buf)
+		return err
+	}
+	w.bufs = append(w.bufs, buf[:n])
+	w.pooled = append(w.pooled, buf)
+	return nil
+}
+
+// 写出收集的内容，之后缓冲放回池中
+func (w *batchWriter) flush(conn net.Conn) error {
+	// WriteTo 会消耗掉切片，用一个副本
+	bufs := w.bufs
+	_, err := bufs.WriteTo(conn)
+	for i, buf := range w.pooled {
+		w.pool.Put(buf)
+		w.pooled[i] = nil
+	}
+	for i := range w.bufs {
+		w.bufs[i] = nil
+	}
+	w.bufs = w.bufs[:0]
+	w.pooled = w.pooled[:0]
+	return err
+}
+
+// 把收到的包放回缓冲池，只有设置了 FramePool 才需要
+func (s *defaultSession) release(val []byte) {
+	if s.option.FramePool != nil {
+		s.option.FramePool.Put(val)
+	}
+}
+
+// 从发送队列中批量取出，合并成一次writev
+// first 是已经从队列中取出的第一个包
+func (s *defaultSession) writeBatch(first []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	sizes := make([]int, 0, s.option.WriteBatch)
+	for msg, count := first, 1; ; count++ {
+		// 单个包的错误，例如超过长度，只跳过这个包
+		if err := s.batch.encode(s.packet(), s.batchOut, msg); err == nil {
+			sizes = append(sizes, len(msg))
+		}
+		if count >= s.option.WriteBatch {
+			break
+		}
+		select {
+		case msg = <-s.sendChan:
+			s.popped(QueueSend)
+			continue
+		default:
+		}
+		break
+	}
+
+	err := s.batch.flush(s.conn)
+	if err == nil {
+		s.lastWrite.Set(time.Now().UnixNano())
+		for _, size := range sizes {
+			s.metrics.send(size)
+		}
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package libnet2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 没有实现 FrameEncoder 的包，批量写入的时候复制到池中
+type copiedPacket struct {
+	packet *LengthPacket
+}
+
+func (p copiedPacket) Read(r *libio.Reader) ([]byte, error) {
+	return p.packet.Read(r)
+}
+
+func (p copiedPacket) Write(w *libio.Writer, b []byte) error {
+	return p.packet.Write(w, b)
+}
+
+func TestBatchWriterEncode(t *testing.T) {
+	pool := libio.NewPool(64, 1024)
+	w := newBatchWriter(pool)
+	out := libio.NewWriter(w)
+	packet := NewLengthPacket(2, BigEndian, 16)
+
+	if err := w.encode(packet, out, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.encode(NewLinePacket(16), out, []byte("line")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.encode(copiedPacket{packet}, out, []byte("copy")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.encode(packet, out, make([]byte, 17)); err == nil {
+		t.Fatal("expected frame size error")
+	}
+	// 编码的缓冲直接来自池，超长的包不会留下缓冲
+	if len(w.bufs) != 3 || len(w.pooled) != 3 {
+		t.Fatalf("expected 3 buffers, got %d %d", len(w.bufs), len(w.pooled))
+	}
+	for i, buf := range w.bufs {
+		if cap(buf) != 64 || &buf[0] != &w.pooled[i][0] {
+			t.Fatalf("buffer %d not pooled, cap %d", i, cap(buf))
+		}
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	done := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 32)
+		n, _ := io.ReadFull(b, buf[:7+5+6])
+		done <- buf[:n]
+	}()
+	if err := w.flush(a); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-done; !bytes.Equal(got, []byte("\x00\x05helloline\n\x00\x04copy")) {
+		t.Fatalf("unexpected output %q", got)
+	}
+	if len(w.bufs) != 0 || len(w.pooled) != 0 {
+		t.Fatalf("buffers not released %d %d", len(w.bufs), len(w.pooled))
+	}
+}
+
+func TestSessionWriteBatch(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	packet := NewLengthPacket(2, BigEndian, 1024)
+	option := DefaultSessionOption()
+	option.SendChanSize = 16
+	option.WriteBatch = 4
+	sess := newDefaultSession(a, option, NewHandler(packet))
+	defer sess.Close()
+	if sess.batch == nil {
+		t.Fatal("expected batch writer")
+	}
+	// 先放进队列，启动之后合并写出
+	for i := 0; i < 10; i++ {
+		if err := sess.Send([]byte(fmt.Sprintf("msg-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sess.Accept()
+
+	b.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := libio.NewReader(b)
+	for i := 0; i < 10; i++ {
+		val, err := packet.Read(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := fmt.Sprintf("msg-%d", i); string(val) != expect {
+			t.Fatalf("expected %s, got %s", expect, val)
+		}
+	}
+	// 写完之后才计数
+	deadline := time.Now().Add(time.Second)
+	for sess.metrics.snapshot().FramesOut != 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 10 frames, got %d", sess.metrics.snapshot().FramesOut)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,298 @@
+package libnet2
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wuqifei/server_lib/concurrent"
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 客户端，主动发起的长连接，本身就是一个 Session2Interface
+// 连接断开之后，按照配置自动重连，回调和服务端的session一致
+// 通过 Recv 设置的回调，以及 Set 的参数和id，重连之后的session继续使用
+type Client struct {
+	option        *ClientOption
+	sessionOption *SessionOption2
+	handler       *Handler
+	id            uint64
+	params        *concurrent.ConcurrentMap
+	// 所有连接累计的统计
+	metrics *netMetrics
+
+	mutex   sync.RWMutex
+	session *defaultSession
+	onRecv  OnSessRecv
+
+	closeFlag *concurrent.AtomicBoolean
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+// 连接服务器，第一次连接失败直接返回错误
+// 返回的是 *Client，需要当前的连接时可以转换之后调用 Session
+// handler 可以不传，不传的时候使用全局的回调
+func Dial(option *ClientOption, sessionOption *SessionOption2, handler ...*Handler) (Session2Interface, error) {
+	c := new(Client)
+	c.option = option
+	c.sessionOption = sessionOption
+	if len(handler) > 0 {
+		c.handler = handler[0]
+	}
+	c.id = globalSessionId.IncrementAndGet()
+	c.params = concurrent.NewCocurrentMap()
+	c.metrics = newGroupMetrics()
+	c.closeFlag = concurrent.NewAtomicBoolean(false)
+	c.closeChan = make(chan bool)
+	c.check()
+
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) check() {
+	if c.option.ReconnectDelay <= 0 {
+		c.option.ReconnectDelay = time.Second
+	}
+	if c.option.ReconnectMaxDelay < c.option.ReconnectDelay {
+		c.option.ReconnectMaxDelay = c.option.ReconnectDelay
+	}
+	if c.option.ReconnectFactor < 1 {
+		c.option.ReconnectFactor = 1
+	}
+}
+
+// 当前的session，重连之后会变化，所以不要一直持有
+func (c *Client) Session() Session2Interface {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.session == nil {
+		return nil
+	}
+	return c.session
+}
+
+func (c *Client) stats() *netMetrics {
+	return c.metrics
+}
+
+// 当前的session是否有发送队列，没有连接的时候 TrySend 直接返回错误，不会阻塞
+func (c *Client) queued() bool {
+	sess := c.current()
+	return sess == nil || sess.queued()
+}
+
+func (c *Client) current() *defaultSession {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.session
+}
+
+// Dial 的时候已经开始接收，这里什么都不做
+func (c *Client) Accept() {
+}
+
+// 通过当前的session发送数据
+func (c *Client) Send(val []byte) error {
+	sess := c.current()
+	if sess == nil {
+		return ErrClientNotConnected
+	}
+	return sess.Send(val)
+}
+
+// 通过当前的session发送请求并等待回复
+func (c *Client) Call(ctx context.Context, req []byte) ([]byte, error) {
+	sess := c.current()
+	if sess == nil {
+		return nil, ErrClientNotConnected
+	}
+	return sess.Call(ctx, req)
+}
+
+// 通过当前的session不阻塞的发送数据
+func (c *Client) TrySend(val []byte) error {
+	sess := c.current()
+	if sess == nil {
+		return ErrClientNotConnected
+	}
+	return sess.TrySend(val)
+}
+
+// 收到信息的回调，优先于handler，重连之后的session继续使用
+func (c *Client) Recv(onRecv OnSessRecv) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onRecv = onRecv
+	if c.session != nil {
+		c.session.Recv(onRecv)
+	}
+}
+
+// 参数保存在客户端上，重连之后不会丢失
+func (c *Client) Set(key, val interface{}) error {
+	if key == nil || val == nil {
+		return ErrValueNull
+	}
+	c.params.Set(key, val)
+	return nil
+}
+
+func (c *Client) Get(key interface{}) (interface{}, error) {
+	if key == nil {
+		return nil, ErrValueNull
+	}
+	return c.params.Get(key), nil
+}
+
+func (c *Client) Del(key interface{}) (bool, error) {
+	if key == nil {
+		return false, ErrValueNull
+	}
+	c.params.Del(key)
+	return true, nil
+}
+
+func (c *Client) Clear() error {
+	c.params.Dispose()
+	params := concurrent.NewCocurrentMap()
+	c.mutex.Lock()
+	c.params = params
+	if c.session != nil {
+		c.session.params = params
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+// 当前的连接，没有连接的时候返回nil
+func (c *Client) GetConn() net.Conn {
+	if sess := c.current(); sess != nil {
+		return sess.GetConn()
+	}
+	return nil
+}
+
+// 客户端的id，每次重连的session都使用这个id
+func (c *Client) GetUniqueID() uint64 {
+	return c.id
+}
+
+// 当前连接的读取，没有连接的时候返回nil
+func (c *Client) Reader() *libio.Reader {
+	if sess := c.current(); sess != nil {
+		return sess.Reader()
+	}
+	return nil
+}
+
+// 当前连接的写入，没有连接的时候返回nil
+func (c *Client) Writer() *libio.Writer {
+	if sess := c.current(); sess != nil {
+		return sess.Writer()
+	}
+	return nil
+}
+
+// 关闭客户端，不会再重连
+func (c *Client) Close() error {
+	c.closeFlag.Set(true)
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
+	if sess := c.current(); sess != nil {
+		return sess.Close()
+	}
+	return nil
+}
+
+func (c *Client) connect() error {
+	var conn net.Conn
+	var err error
+	if c.option.Dialer != nil {
+		conn, err = c.option.Dialer(c.option)
+	} else if c.option.Network == NetworkWebSocket {
+		conn, err = dialWebSocket(c.option)
+	} else if isUDPNetwork(c.option.Network) {
+		conn, err = dialUDP(c.option)
+	} else if c.option.TLSConfig != nil {
+		dialer := &net.Dialer{Timeout: c.option.DialTimeout}
+		conn, err = tls.DialWithDialer(dialer, c.option.Network, c.option.Address, c.option.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout(c.option.Network, c.option.Address, c.option.DialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	session := newDefaultSession(conn, c.sessionOption, c.handler)
+	session.setUniqueID(c.id)
+	session.metrics.parent = c.metrics
+	c.mutex.Lock()
+	session.params = c.params
+	session.onRecv = c.onRecv
+	c.mutex.Unlock()
+	if onSession := c.handler.onSession(); onSession != nil {
+		onSession(session)
+	}
+	session.onClose = c.onSessionClose
+
+	c.mutex.Lock()
+	c.session = session
+	c.mutex.Unlock()
+	session.Accept()
+	if c.closeFlag.Get() {
+		// 连接的过程中客户端被关闭了
+		session.Close()
+	}
+	return nil
+}
+
+func (c *Client) onSessionClose(sess Session2Interface) {
+	c.mutex.Lock()
+	if c.session == sess {
+		c.session = nil
+	}
+	c.mutex.Unlock()
+
+	if c.closeFlag.Get() || !c.option.Reconnect {
+		return
+	}
+	go c.reconnect()
+}
+
+// 按照退避的间隔重连，直到成功，或者超过次数，或者客户端被关闭
+func (c *Client) reconnect() {
+	delay := c.option.ReconnectDelay
+	for times := 1; c.option.ReconnectTimes <= 0 || times <= c.option.ReconnectTimes; times++ {
+		select {
+		case <-c.closeChan:
+			return
+		case <-time.After(delay):
+		}
+
+		if c.closeFlag.Get() {
+			return
+		}
+		err := c.connect()
+		if err == nil {
+			return
+		}
+		if onError := c.handler.onError(); onError != nil {
+			onError(err)
+		}
+
+		delay = time.Duration(float64(delay) * c.option.ReconnectFactor)
+		if delay > c.option.ReconnectMaxDelay {
+			delay = c.option.ReconnectMaxDelay
+		}
+	}
+	if onError := c.handler.onError(); onError != nil {
+		onError(ErrClientReconnect)
+	}
+}
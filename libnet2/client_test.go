@@ -0,0 +1,120 @@
+package libnet2_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libnet2"
+	"github.com/wuqifei/server_lib/libnet2/nettest"
+)
+
+func newEchoServer(t *testing.T, packet libnet2.PacketInterface) *nettest.Server {
+	handler := new(libnet2.Handler)
+	handler.OnRecv = func(sess libnet2.Session2Interface, val []byte) {
+		sess.Send(append([]byte(nil), val...))
+	}
+	s, err := nettest.NewServer(packet, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestClientReconnect(t *testing.T) {
+	packet := libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024)
+	s := newEchoServer(t, packet)
+
+	// 连接当前的服务，记录每次连接的时间
+	var mutex sync.Mutex
+	server := s
+	var dials []time.Time
+	option := libnet2.DefaultClientOption()
+	option.ReconnectDelay = 20 * time.Millisecond
+	option.ReconnectFactor = 2
+	option.ReconnectMaxDelay = 80 * time.Millisecond
+	option.Dialer = func(*libnet2.ClientOption) (net.Conn, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		dials = append(dials, time.Now())
+		return server.Listener.Dial()
+	}
+
+	handler := libnet2.NewHandler(packet)
+	handler.OnError = func(err error) {}
+	sess, err := libnet2.Dial(option, libnet2.DefaultSessionOption(), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	client := sess.(*libnet2.Client)
+	id := client.GetUniqueID()
+	recvChan := make(chan string, 4)
+	client.Recv(func(sess libnet2.Session2Interface, val []byte) {
+		recvChan <- string(val)
+	})
+	client.Set("user", "u1")
+
+	expect := func(msg string) {
+		t.Helper()
+		select {
+		case got := <-recvChan:
+			if got != msg {
+				t.Fatalf("expected %q, got %q", msg, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%q not received", msg)
+		}
+	}
+	if err = client.Send([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	expect("first")
+
+	// 停掉服务，客户端按照退避的间隔一直重连失败
+	s.Close()
+	time.Sleep(300 * time.Millisecond)
+	if err = client.Send([]byte("lost")); err != libnet2.ErrClientNotConnected {
+		t.Fatalf("expected not connected, got %v", err)
+	}
+
+	mutex.Lock()
+	failed := append([]time.Time(nil), dials[1:]...)
+	server = newEchoServer(t, packet)
+	mutex.Unlock()
+	defer server.Close()
+	if len(failed) < 3 {
+		t.Fatalf("expected at least 3 reconnects, got %d", len(failed))
+	}
+	// 间隔按照系数增长，不超过最大值
+	for i := 1; i < len(failed); i++ {
+		gap := failed[i].Sub(failed[i-1])
+		want := 20 * time.Millisecond << uint(i)
+		if want > option.ReconnectMaxDelay {
+			want = option.ReconnectMaxDelay
+		}
+		if gap < want-5*time.Millisecond {
+			t.Fatalf("reconnect %d after %v, expected %v", i, gap, want)
+		}
+	}
+
+	// 新的服务起来之后重连成功，回调，参数和id都还在
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Session() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("client not reconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = client.Send([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	expect("second")
+	if val, _ := client.Get("user"); val != "u1" {
+		t.Fatalf("expected param kept, got %v", val)
+	}
+	if client.GetUniqueID() != id || client.Session().GetUniqueID() != id {
+		t.Fatalf("expected id %d kept", id)
+	}
+}
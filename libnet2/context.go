@@ -0,0 +1,96 @@
+package libnet2
+
+// 中断之后的下标，保证后面的处理不会再被执行
+const abortIndex = 1 << 30
+
+// 一次路由处理的上下文，只在处理过程中有效，不要在处理函数之外持有
+type Context struct {
+	// 收到信息的session
+	Session Session2Interface
+	// 命令号
+	Cmd uint32
+	// 去掉命令号之后的包体
+	Body []byte
+
+	router   *Router
+	handlers []RouteFunc
+	index    int
+	keys     map[string]interface{}
+}
+
+func newContext(router *Router, sess Session2Interface, cmd uint32, body []byte, handlers []RouteFunc) *Context {
+	ctx := new(Context)
+	ctx.router = router
+	ctx.Session = sess
+	ctx.Cmd = cmd
+	ctx.Body = body
+	ctx.handlers = handlers
+	ctx.index = -1
+	return ctx
+}
+
+// 执行后面的处理，只能在中间件中调用
+func (c *Context) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+// 中断后面的处理
+func (c *Context) Abort() {
+	c.index = abortIndex
+}
+
+// 是否已经中断
+func (c *Context) IsAborted() bool {
+	return c.index >= abortIndex
+}
+
+// 设置本次处理过程中的参数，在中间件之间传值
+func (c *Context) Set(key string, val interface{}) {
+	if c.keys == nil {
+		c.keys = make(map[string]interface{})
+	}
+	c.keys[key] = val
+}
+
+// 获取本次处理过程中的参数
+func (c *Context) Get(key string) (interface{}, bool) {
+	val, ok := c.keys[key]
+	return val, ok
+}
+
+// 按照路由的序列化策略把包体解析到v
+func (c *Context) Bind(v interface{}) error {
+	return c.router.Serializer().Unmarshal(c.Body, v)
+}
+
+// 编码之后用同样的命令号回复
+func (c *Context) ReplyValue(v interface{}) error {
+	return c.SendValue(c.Cmd, v)
+}
+
+// 编码之后用指定的命令号发送
+func (c *Context) SendValue(cmd uint32, v interface{}) error {
+	body, err := c.router.Serializer().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(cmd, body)
+}
+
+// 用同样的命令号回复
+func (c *Context) Reply(body []byte) error {
+	return c.Send(c.Cmd, body)
+}
+
+// 用指定的命令号发送
+func (c *Context) Send(cmd uint32, body []byte) error {
+	val, err := c.router.codec.Encode(cmd, body)
+	if err != nil {
+		return err
+	}
+	return c.Session.Send(val)
+}
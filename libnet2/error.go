@@ -5,4 +5,71 @@ import "errors"
 var (
 	// 值为空的错误
 	ErrValueNull = errors.New("value or key is error")
+
+	// session已经关闭
+	ErrSessionClosed = errors.New("conn closed")
+	// session不在hub中，例如已经关闭
+	ErrHubSessionNull = errors.New("session not in hub")
+
+	// 包的长度不够解析命令号
+	ErrRouteCmdShort = errors.New("packet too short to decode route cmd")
+	// 路由没有处理函数
+	ErrRouteHandlerNull = errors.New("route handler is null")
+
+	// 队列已满
+	ErrQueueFull = errors.New("session queue full")
+	// 等待队列超时
+	ErrQueueTimeout = errors.New("session queue timeout")
+
+	// session没有配置rpc
+	ErrRPCDisabled = errors.New("session rpc codec is null")
+	// 对端没有处理rpc请求的回调
+	ErrRPCHandlerNull = errors.New("rpc handler is null")
+
+	// protobuf序列化的值不是 proto.Message
+	ErrNotProtoMessage = errors.New("value is not proto message")
+
+	// 超过最大连接数
+	ErrConnLimit = errors.New("too many connections")
+	// 超过单个ip的最大连接数
+	ErrIPConnLimit = errors.New("too many connections from ip")
+	// 超过建立连接的速率
+	ErrAcceptRate = errors.New("accept rate exceeded")
+	// ip不允许连接
+	ErrIPDenied = errors.New("ip denied")
+	// 收包太快，session被关闭
+	ErrRecvRateLimit = errors.New("recv rate exceeded")
+
+	// reactor模式不支持，只有linux下的tcp可以使用
+	ErrReactorUnsupported = errors.New("reactor mode unsupported")
+
+	// 分隔符的包中间不能有分隔符，否则对端会拆成两个包
+	ErrDelimiterInFrame = errors.New("frame contains delimiter")
+
+	// PROXY protocol的包头不合法
+	ErrProxyHeader = errors.New("invalid proxy protocol header")
+
+	// 监听不能交给新的进程，例如不是这个包创建的服务
+	ErrRestartUnsupported = errors.New("hot restart unsupported")
+	// 热重启启动的新的进程没有准备好，已经被杀掉
+	ErrRestartNotReady = errors.New("restarted process not ready")
+
+	// 客户端当前没有连接
+	ErrClientNotConnected = errors.New("client not connected")
+	// 客户端超过重连次数
+	ErrClientReconnect = errors.New("client reconnect times exceeded")
+
+	// 不是tls的连接
+	ErrNotTLS = errors.New("conn is not tls")
+	// 不是按消息读写的连接
+	ErrNotMessageConn = errors.New("conn is not message conn")
+	// 不是这个包创建的session，没有统计
+	ErrStatsUnsupported = errors.New("session stats unsupported")
+
+	// 监听或者连接已经关闭，和net包的错误信息一致，Run和session中按照这个判断
+	errClosed = errors.New("use of closed network connection")
+	// 读取超时
+	errTimeout = &netError{msg: "i/o timeout", timeout: true}
+	// 可靠udp超过重传次数
+	errARQTimeout = &netError{msg: "arq retransmit times exceeded", timeout: true}
 )
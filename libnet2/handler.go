@@ -0,0 +1,109 @@
+package libnet2
+
+// 服务的回调以及解析对象
+// 每个服务各自持有一份，这样同一个进程中可以跑多个不同协议的服务
+// 字段为空的时候，回退到包级别的全局变量，兼容以前的用法
+type Handler struct {
+	// 服务收到错误的回调
+	OnError OnError
+
+	// 服务收到 session的回调
+	OnSession OnSession
+
+	// session收到信息
+	OnRecv OnSessRecv
+	// session关闭
+	OnClose OnSessClose
+	// session错误
+	OnSessError OnSessError
+	// session空闲，在 SessionOption2 中配置空闲的时间
+	OnIdle OnSessIdle
+	// 队列达到高水位和回落到低水位，在 SessionOption2 中配置水位
+	OnHighWatermark OnSessWatermark
+	OnLowWatermark  OnSessWatermark
+	// 收到对端的rpc请求，在 SessionOption2 中配置rpc的编码
+	OnCall OnSessCall
+
+	// 解析的对象
+	Packet PacketInterface
+}
+
+// 新建一个空的handler，没有设置的字段使用全局变量
+func NewHandler(packet PacketInterface) *Handler {
+	h := new(Handler)
+	h.Packet = packet
+	return h
+}
+
+// 下面的取值都允许handler为nil，全局变量需要在使用时再取，
+// 因为以前的用法是先Run，再设置全局变量
+
+func (h *Handler) packet() PacketInterface {
+	if h != nil && h.Packet != nil {
+		return h.Packet
+	}
+	return ServerPacket
+}
+
+func (h *Handler) onError() OnError {
+	if h != nil && h.OnError != nil {
+		return h.OnError
+	}
+	return ServerErrorBlock
+}
+
+func (h *Handler) onSession() OnSession {
+	if h != nil && h.OnSession != nil {
+		return h.OnSession
+	}
+	return ServerSessionBlock
+}
+
+func (h *Handler) onRecv() OnSessRecv {
+	if h != nil && h.OnRecv != nil {
+		return h.OnRecv
+	}
+	return SessionRecvBlock
+}
+
+func (h *Handler) onClose() OnSessClose {
+	if h != nil && h.OnClose != nil {
+		return h.OnClose
+	}
+	return SessionCloseBlock
+}
+
+func (h *Handler) onIdle() OnSessIdle {
+	if h != nil {
+		return h.OnIdle
+	}
+	return nil
+}
+
+func (h *Handler) onHighWatermark() OnSessWatermark {
+	if h != nil {
+		return h.OnHighWatermark
+	}
+	return nil
+}
+
+func (h *Handler) onLowWatermark() OnSessWatermark {
+	if h != nil {
+		return h.OnLowWatermark
+	}
+	return nil
+}
+
+func (h *Handler) onCall() OnSessCall {
+	if h != nil {
+		return h.OnCall
+	}
+	return nil
+}
+
+func (h *Handler) onSessError() OnSessError {
+	if h != nil && h.OnSessError != nil {
+		return h.OnSessError
+	}
+	return SessionErrorBlock
+}
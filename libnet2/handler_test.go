@@ -0,0 +1,98 @@
+package libnet2_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+	"github.com/wuqifei/server_lib/libnet2"
+)
+
+// 在本机的随机端口上运行服务，handler为nil的时候使用全局的回调
+func runServer(t *testing.T, handler *libnet2.Handler) libnet2.LibserverInterface {
+	option := libnet2.DefaultOption()
+	option.Address = "127.0.0.1:0"
+	var server libnet2.LibserverInterface
+	var err error
+	if handler == nil {
+		server, err = libnet2.NewWithOption(option, libnet2.DefaultSessionOption())
+	} else {
+		server, err = libnet2.NewWithOption(option, libnet2.DefaultSessionOption(), handler)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Run()
+	return server
+}
+
+// 按照packet发送一个包，等待回复
+func roundTrip(t *testing.T, server libnet2.LibserverInterface, packet libnet2.PacketInterface, msg string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener().Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err = packet.Write(libio.NewWriter(conn), []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	val, err := packet.Read(libio.NewReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(val)
+}
+
+func prefixHandler(packet libnet2.PacketInterface, prefix string) *libnet2.Handler {
+	handler := libnet2.NewHandler(packet)
+	handler.OnRecv = func(sess libnet2.Session2Interface, val []byte) {
+		sess.Send(append([]byte(prefix), val...))
+	}
+	return handler
+}
+
+func TestServersWithOwnHandler(t *testing.T) {
+	length := libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024)
+	line := libnet2.NewLinePacket(1024)
+	a := runServer(t, prefixHandler(length, "a:"))
+	defer a.Close()
+	b := runServer(t, prefixHandler(line, "b:"))
+	defer b.Close()
+
+	// 同一个进程中两种协议，各自的解析和回调互不影响
+	for i := 0; i < 3; i++ {
+		if got := roundTrip(t, a, length, "hello"); got != "a:hello" {
+			t.Fatalf("expected a:hello, got %q", got)
+		}
+		if got := roundTrip(t, b, line, "hello"); got != "b:hello" {
+			t.Fatalf("expected b:hello, got %q", got)
+		}
+	}
+}
+
+func TestServerGlobalHandler(t *testing.T) {
+	uvarint := libnet2.NewUvarintPacket(1024)
+	libnet2.ServerPacket = uvarint
+	libnet2.SessionRecvBlock = func(sess libnet2.Session2Interface, val []byte) {
+		sess.Send(append([]byte("global:"), val...))
+	}
+	defer func() {
+		libnet2.ServerPacket = nil
+		libnet2.SessionRecvBlock = nil
+	}()
+
+	// 不传handler的时候使用全局变量，传了的服务不受影响
+	global := runServer(t, nil)
+	defer global.Close()
+	own := runServer(t, prefixHandler(libnet2.NewLinePacket(1024), "own:"))
+	defer own.Close()
+	if got := roundTrip(t, global, uvarint, "hello"); got != "global:hello" {
+		t.Fatalf("expected global:hello, got %q", got)
+	}
+	if got := roundTrip(t, own, libnet2.NewLinePacket(1024), "hello"); got != "own:hello" {
+		t.Fatalf("expected own:hello, got %q", got)
+	}
+}
@@ -0,0 +1,151 @@
+package libnet2
+
+import (
+	"bytes"
+	"time"
+)
+
+// 空闲的类型
+type IdleState int
+
+const (
+	// 一段时间没有收到数据
+	IdleRead IdleState = iota
+	// 一段时间没有写出数据
+	IdleWrite
+	// 一段时间既没有收到也没有写出数据
+	IdleAll
+)
+
+func (state IdleState) String() string {
+	switch state {
+	case IdleRead:
+		return "read idle"
+	case IdleWrite:
+		return "write idle"
+	default:
+		return "all idle"
+	}
+}
+
+// session空闲的回调，由使用者决定关闭还是发送探测
+type OnSessIdle func(sess Session2Interface, state IdleState)
+
+// 心跳包的策略
+// 心跳包和普通的包一样经过 PacketInterface 编解码，收到的心跳包不会交给上层
+type HeartbeatInterface interface {
+	// 发送的心跳包
+	Ping() []byte
+	// 收到心跳包之后回复的包
+	Pong(ping []byte) []byte
+	// 是否是心跳包
+	IsPing(val []byte) bool
+	// 是否是回复的包
+	IsPong(val []byte) bool
+}
+
+// 固定内容的心跳包
+type BytesHeartbeat struct {
+	ping []byte
+	pong []byte
+}
+
+// 新建固定内容的心跳包，ping和pong不能和业务的包冲突
+func NewBytesHeartbeat(ping, pong []byte) *BytesHeartbeat {
+	h := new(BytesHeartbeat)
+	h.ping = ping
+	h.pong = pong
+	return h
+}
+
+func (h *BytesHeartbeat) Ping() []byte {
+	return h.ping
+}
+
+func (h *BytesHeartbeat) Pong(ping []byte) []byte {
+	return h.pong
+}
+
+func (h *BytesHeartbeat) IsPing(val []byte) bool {
+	return bytes.Equal(val, h.ping)
+}
+
+func (h *BytesHeartbeat) IsPong(val []byte) bool {
+	return bytes.Equal(val, h.pong)
+}
+
+// 检查的间隔，取所有配置中最短的一半
+func (s *defaultSession) tickPeriod() time.Duration {
+	period := s.option.ReadTimeout
+	for _, d := range []time.Duration{s.option.ReadIdle, s.option.WriteIdle, s.option.AllIdle, s.option.HeartbeatInterval} {
+		if d > 0 && d < period {
+			period = d
+		}
+	}
+	period /= 2
+	if min := 10 * time.Millisecond; period < min {
+		period = min
+	}
+	return period
+}
+
+// 定时检查读取超时，心跳以及空闲，返回false的时候关闭session
+func (s *defaultSession) tick(now time.Time) bool {
+	lastRead := s.lastRead.Get()
+	lastWrite := s.lastWrite.Get()
+	readElapsed := now.Sub(time.Unix(0, lastRead))
+
+	// 读取超时，超过次数之后关闭
+	times := int32(readElapsed / s.option.ReadTimeout)
+	s.timeoutTimes.Set(times)
+	if times > int32(s.option.ReadTimeoutTimes) {
+		return false
+	}
+
+	onIdle := s.handler.onIdle()
+	if hb := s.option.Heartbeat; hb != nil && s.option.HeartbeatInterval > 0 {
+		if now.Sub(time.Unix(0, lastWrite)) >= s.option.HeartbeatInterval {
+			// 经过发送队列，不在处理协程中等待对端
+			// 队列满了说明对端一直没有读，和写空闲一样通知上层
+			if s.TrySend(hb.Ping()) == ErrQueueFull && onIdle != nil && s.writeIdleAt != lastWrite {
+				s.writeIdleAt = lastWrite
+				onIdle(s, IdleWrite)
+			}
+		}
+	}
+
+	if onIdle == nil {
+		return true
+	}
+	if s.option.ReadIdle > 0 && readElapsed >= s.option.ReadIdle && s.readIdleAt != lastRead {
+		s.readIdleAt = lastRead
+		onIdle(s, IdleRead)
+	}
+	if s.option.WriteIdle > 0 && now.Sub(time.Unix(0, lastWrite)) >= s.option.WriteIdle && s.writeIdleAt != lastWrite {
+		s.writeIdleAt = lastWrite
+		onIdle(s, IdleWrite)
+	}
+	lastActive := lastRead
+	if lastWrite > lastActive {
+		lastActive = lastWrite
+	}
+	if s.option.AllIdle > 0 && now.Sub(time.Unix(0, lastActive)) >= s.option.AllIdle && s.allIdleAt != lastActive {
+		s.allIdleAt = lastActive
+		onIdle(s, IdleAll)
+	}
+	return true
+}
+
+// 处理心跳包，是心跳包的时候返回true
+func (s *defaultSession) heartbeat(val []byte) bool {
+	hb := s.option.Heartbeat
+	if hb == nil {
+		return false
+	}
+	if hb.IsPing(val) {
+		// 不阻塞读取，队列满的时候丢弃这次回复
+		s.TrySend(hb.Pong(val))
+		return true
+	}
+	return hb.IsPong(val)
+}
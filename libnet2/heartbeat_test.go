@@ -0,0 +1,91 @@
+package libnet2_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libnet2"
+	"github.com/wuqifei/server_lib/libnet2/nettest"
+)
+
+func TestHeartbeatAndIdle(t *testing.T) {
+	option := libnet2.DefaultSessionOption()
+	option.Heartbeat = libnet2.NewBytesHeartbeat([]byte("ping"), []byte("pong"))
+	option.HeartbeatInterval = 20 * time.Millisecond
+	option.ReadIdle = 100 * time.Millisecond
+	idleChan := make(chan libnet2.IdleState, 4)
+	handler := new(libnet2.Handler)
+	handler.OnIdle = func(sess libnet2.Session2Interface, state libnet2.IdleState) {
+		idleChan <- state
+		sess.Close()
+	}
+	s, err := nettest.NewServer(libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024), option, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有写出数据的时候主动发送心跳
+	if err = c.Expect([]byte("ping"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// 收到心跳回复，不交给上层
+	if err = c.Send([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		frame, err := c.Recv(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(frame) == "pong" {
+			break
+		}
+	}
+	if n := s.Recorder.Count(nettest.EventRecv); n != 0 {
+		t.Fatalf("expected heartbeat not delivered, got %d", n)
+	}
+
+	// 心跳只算写出，客户端不发数据的时候读空闲，回调中关闭
+	select {
+	case state := <-idleChan:
+		if state != libnet2.IdleRead {
+			t.Fatalf("expected read idle, got %v", state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle not detected")
+	}
+	if err = c.WaitClose(time.Second); err == nil || err == nettest.ErrTimeout {
+		t.Fatalf("expected closed, got %v", err)
+	}
+}
+
+func TestReadTimeoutClose(t *testing.T) {
+	option := libnet2.DefaultSessionOption()
+	option.ReadTimeout = 30 * time.Millisecond
+	option.ReadTimeoutTimes = 1
+	s, err := nettest.NewServer(libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024), option, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err = s.Recorder.Wait(nettest.EventClose, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// 超过两个超时的时间才关闭
+	if cost := time.Since(start); cost < 2*option.ReadTimeout {
+		t.Fatalf("closed after %v", cost)
+	}
+	if err = c.WaitClose(time.Second); err == nil || err == nettest.ErrTimeout {
+		t.Fatalf("expected closed, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package libnet2
+
+import (
+	"sync"
+
+	"github.com/wuqifei/server_lib/concurrent"
+)
+
+// session的管理，按照id查找，广播，以及按照组(房间)发送
+// 服务端会自动把session加入和删除，客户端的session需要自己管理
+type SessionHub struct {
+	sessions *concurrent.ConcurrentIDGroupMap
+
+	// 组的修改，以及删除session的时候持有，保证删除之后不会再加入组
+	mutex sync.RWMutex
+	// 组名对应的session
+	groups map[string]map[uint64]Session2Interface
+	// session加入的组，删除的时候使用
+	joined map[uint64]map[string]bool
+}
+
+// 新建session管理
+func NewSessionHub() *SessionHub {
+	h := new(SessionHub)
+	h.sessions = concurrent.NewCocurrentIDGroup()
+	h.groups = make(map[string]map[uint64]Session2Interface)
+	h.joined = make(map[uint64]map[string]bool)
+	return h
+}
+
+// 加入session
+func (h *SessionHub) Add(sess Session2Interface) {
+	h.sessions.Set(sess.GetUniqueID(), sess)
+}
+
+// 删除session，同时退出所有加入的组
+func (h *SessionHub) Del(sess Session2Interface) {
+	id := sess.GetUniqueID()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sessions.Del(id)
+	for group := range h.joined[id] {
+		h.leave(group, id)
+	}
+	delete(h.joined, id)
+}
+
+// 按照id查找，没有的时候返回nil
+func (h *SessionHub) Get(id uint64) Session2Interface {
+	item := h.sessions.Get(id)
+	if item == nil {
+		return nil
+	}
+	return item.(Session2Interface)
+}
+
+// session的数量
+func (h *SessionHub) Count() int {
+	return int(h.sessions.Count())
+}
+
+// 遍历所有的session，fn返回false的时候停止
+func (h *SessionHub) Range(fn func(sess Session2Interface) bool) {
+	for _, sess := range h.snapshot() {
+		if !fn(sess) {
+			return
+		}
+	}
+}
+
+// 发送给所有的session，返回发送成功和失败的数量
+// 使用不阻塞的 TrySend，队列满的session算作失败，不会卡住其他的session
+// 没有发送队列的session（SendChanSize 不超过1）TrySend 会直接写连接，不发送，算作失败
+func (h *SessionHub) Broadcast(val []byte) (sent, failed int) {
+	return sendAll(h.snapshot(), val)
+}
+
+// 加入组，组不存在的时候自动创建
+// session不在hub中的时候返回 ErrHubSessionNull，例如已经关闭的session
+func (h *SessionHub) Join(group string, sess Session2Interface) error {
+	id := sess.GetUniqueID()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.sessions.Get(id) == nil {
+		return ErrHubSessionNull
+	}
+	members, ok := h.groups[group]
+	if !ok {
+		members = make(map[uint64]Session2Interface)
+		h.groups[group] = members
+	}
+	members[id] = sess
+	groups, ok := h.joined[id]
+	if !ok {
+		groups = make(map[string]bool)
+		h.joined[id] = groups
+	}
+	groups[group] = true
+	return nil
+}
+
+// 退出组，组为空的时候自动删除
+func (h *SessionHub) Leave(group string, sess Session2Interface) {
+	id := sess.GetUniqueID()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.leave(group, id)
+	if groups, ok := h.joined[id]; ok {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(h.joined, id)
+		}
+	}
+}
+
+func (h *SessionHub) leave(group string, id uint64) {
+	members, ok := h.groups[group]
+	if !ok {
+		return
+	}
+	delete(members, id)
+	if len(members) == 0 {
+		delete(h.groups, group)
+	}
+}
+
+// 组内的session
+func (h *SessionHub) Members(group string) []Session2Interface {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	members := h.groups[group]
+	list := make([]Session2Interface, 0, len(members))
+	for _, sess := range members {
+		list = append(list, sess)
+	}
+	return list
+}
+
+// 发送给组内所有的session，返回发送成功和失败的数量，和 Broadcast 一样不会阻塞
+func (h *SessionHub) Multicast(group string, val []byte) (sent, failed int) {
+	return sendAll(h.Members(group), val)
+}
+
+// 先复制一份，发送的时候不持有锁，避免阻塞的发送卡住其他操作
+func (h *SessionHub) snapshot() []Session2Interface {
+	list := make([]Session2Interface, 0, h.Count())
+	h.sessions.Range(func(id uint64, item interface{}) bool {
+		list = append(list, item.(Session2Interface))
+		return true
+	})
+	return list
+}
+
+// 知道自己有没有发送队列的session
+type queuedSession interface {
+	queued() bool
+}
+
+func sendAll(list []Session2Interface, val []byte) (sent, failed int) {
+	for _, sess := range list {
+		if qs, ok := sess.(queuedSession); ok && !qs.queued() {
+			// 直接写连接的时候，一个慢的对端会卡住整个广播
+			failed++
+			continue
+		}
+		if err := sess.TrySend(val); err == nil {
+			sent++
+		} else {
+			failed++
+		}
+	}
+	return sent, failed
+}
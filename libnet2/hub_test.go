@@ -0,0 +1,149 @@
+package libnet2_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libnet2"
+	"github.com/wuqifei/server_lib/libnet2/nettest"
+)
+
+// 只有id和发送的session，err 为 TrySend 返回的错误
+type hubSession struct {
+	libnet2.Session2Interface
+	id   uint64
+	err  error
+	sent int
+}
+
+func (s *hubSession) GetUniqueID() uint64 {
+	return s.id
+}
+
+func (s *hubSession) TrySend(val []byte) error {
+	if s.err == nil {
+		s.sent++
+	}
+	return s.err
+}
+
+func TestSessionHubGroups(t *testing.T) {
+	hub := libnet2.NewSessionHub()
+	a, b := &hubSession{id: 1}, &hubSession{id: 2}
+	hub.Add(a)
+	hub.Add(b)
+	if hub.Count() != 2 || hub.Get(1) != a || hub.Get(3) != nil {
+		t.Fatalf("unexpected hub %d %v", hub.Count(), hub.Get(1))
+	}
+
+	if err := hub.Join("room", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Join("room", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Join("room", &hubSession{id: 3}); err != libnet2.ErrHubSessionNull {
+		t.Fatalf("expected join rejected, got %v", err)
+	}
+	hub.Leave("room", b)
+	if members := hub.Members("room"); len(members) != 1 || members[0] != a {
+		t.Fatalf("unexpected members %v", members)
+	}
+
+	// 删除的时候退出所有的组，之后不能再加入
+	hub.Del(a)
+	if members := hub.Members("room"); len(members) != 0 {
+		t.Fatalf("expected empty group, got %v", members)
+	}
+	if err := hub.Join("room", a); err != libnet2.ErrHubSessionNull {
+		t.Fatalf("expected join after del rejected, got %v", err)
+	}
+	if hub.Count() != 1 {
+		t.Fatalf("expected 1 session, got %d", hub.Count())
+	}
+}
+
+func TestSessionHubBroadcast(t *testing.T) {
+	hub := libnet2.NewSessionHub()
+	a := &hubSession{id: 1}
+	full := &hubSession{id: 2, err: libnet2.ErrQueueFull}
+	hub.Add(a)
+	hub.Add(full)
+	hub.Join("room", full)
+
+	// 队列满的session不会卡住广播，算作失败
+	if sent, failed := hub.Broadcast([]byte("all")); sent != 1 || failed != 1 {
+		t.Fatalf("expected 1 sent 1 failed, got %d %d", sent, failed)
+	}
+	if sent, failed := hub.Multicast("room", []byte("room")); sent != 0 || failed != 1 {
+		t.Fatalf("expected 0 sent 1 failed, got %d %d", sent, failed)
+	}
+	if sent, failed := hub.Multicast("none", []byte("none")); sent != 0 || failed != 0 {
+		t.Fatalf("expected nothing sent, got %d %d", sent, failed)
+	}
+	if a.sent != 1 {
+		t.Fatalf("expected 1 frame, got %d", a.sent)
+	}
+}
+
+func TestSessionHubCleanupOnClose(t *testing.T) {
+	s := newEchoServer(t, libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024))
+	defer s.Close()
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Recorder.Wait(nettest.EventSession, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := s.Server.Hub()
+	if err = hub.Join("room", e.Sess); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	if _, err = s.Recorder.Wait(nettest.EventClose, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭的回调在删除之后执行
+	if hub.Count() != 0 || len(hub.Members("room")) != 0 {
+		t.Fatalf("expected hub cleaned, got %d %v", hub.Count(), hub.Members("room"))
+	}
+	if err = hub.Join("room", e.Sess); err != libnet2.ErrHubSessionNull {
+		t.Fatalf("expected join after close rejected, got %v", err)
+	}
+}
+
+func TestSessionHubBroadcastNoQueue(t *testing.T) {
+	option := libnet2.DefaultSessionOption()
+	option.SendChanSize = 1
+	s, err := nettest.NewServer(libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024), option, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		if _, err = s.Dial(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = s.Recorder.Wait(nettest.EventSession, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 没有发送队列的时候直接写连接，客户端不读会卡住，广播跳过这样的session
+	done := make(chan [2]int, 1)
+	go func() {
+		sent, failed := s.Server.Hub().Broadcast([]byte("all"))
+		done <- [2]int{sent, failed}
+	}()
+	select {
+	case n := <-done:
+		if n[0] != 0 || n[1] != 2 {
+			t.Fatalf("expected 0 sent 2 failed, got %d %d", n[0], n[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast blocked")
+	}
+}
@@ -1,6 +1,7 @@
 package libnet2
 
 import (
+	"context"
 	"net"
 
 	"github.com/wuqifei/server_lib/libio"
@@ -33,6 +34,12 @@ type Session2Interface interface {
 	//  发送数据
 	Send([]byte) error
 
+	// 不阻塞的发送，队列满的时候返回 ErrQueueFull
+	TrySend([]byte) error
+
+	// 发送请求并等待对端的回复，需要在 SessionOption2 中配置rpc的编码
+	Call(ctx context.Context, req []byte) ([]byte, error)
+
 	// 关闭
 	Close() error
 
@@ -73,9 +80,18 @@ type LibserverInterface interface {
 	// 网络监听返回
 	Listener() net.Listener
 
+	// 存活的session，可以查找，广播，以及按组发送
+	Hub() *SessionHub
+
 	// 启动
 	Run()
 
-	// 关闭
+	// 关闭监听，reactor模式下循环上的session也会关闭
 	Close()
+
+	// 优雅关闭，等待所有session处理完毕，ctx到期之后强制关闭
+	Shutdown(ctx context.Context) error
+
+	// 这个服务的session的统计
+	Stats() Stats
 }
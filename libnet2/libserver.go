@@ -1,9 +1,12 @@
 package libnet2
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/wuqifei/server_lib/concurrent"
@@ -14,8 +17,43 @@ type defaultLibServer struct {
 	listener      net.Listener
 	sessionOption *SessionOption2
 	serverOption  *NetOption
+	handler       *Handler
 	errorChan     chan error
 	connCount     *concurrent.AtomicInt32
+	// MaxConn的名额，Accept之前先取一个，session关闭之后归还
+	connSlots chan bool
+	closeOnce sync.Once
+	closeChan chan bool
+
+	// 最下层的监听，热重启的时候交给新的进程
+	socket *inheritSocket
+
+	// reactor模式的事件循环，为nil的时候每个连接两个协程
+	reactor *reactor
+
+	// 存活的session
+	hub *SessionHub
+	// 这个服务的统计，session的统计累加到这里
+	metrics *netMetrics
+	// 优雅关闭的时候使用
+	// hub是公开的，使用者可以往里面加自己的session，所以服务的session单独记录
+	mutex        sync.Mutex
+	sessions     map[serverSession]bool
+	sessionWait  sync.WaitGroup
+	shutdownFlag bool
+}
+
+// 服务管理的session，普通模式和reactor模式都要实现
+type serverSession interface {
+	Session2Interface
+	// 服务内部使用的关闭回调
+	setCloseHook(onClose OnSessClose)
+	// 优雅关闭
+	shutdown()
+	// 强制关闭
+	forceClose()
+	// session的统计
+	stats() *netMetrics
 }
 
 // 新建服务器
@@ -24,6 +62,10 @@ func newServer() *defaultLibServer {
 	//  新建一个错误的通道
 	s.errorChan = make(chan error, 10)
 	s.connCount = concurrent.NewAtomicInt32(0)
+	s.hub = NewSessionHub()
+	s.metrics = newGroupMetrics()
+	s.sessions = make(map[serverSession]bool)
+	s.closeChan = make(chan bool)
 	return s
 }
 
@@ -31,15 +73,86 @@ func (s *defaultLibServer) Listener() net.Listener {
 	return s.listener
 }
 
-// 关闭
+// 存活的session
+func (s *defaultLibServer) Hub() *SessionHub {
+	return s.hub
+}
+
+// 这个服务的session的统计
+func (s *defaultLibServer) Stats() Stats {
+	return s.metrics.snapshot()
+}
+
+// 关闭监听，reactor模式下循环也会停止，循环上的session一起关闭
 func (s *defaultLibServer) Close() {
+	s.stop()
+	s.closeReactor()
+}
 
+// 停止监听
+func (s *defaultLibServer) stop() {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+		if s.serverOption.MetricsName != "" {
+			serverVars.Delete(s.serverOption.MetricsName)
+		}
+	})
 	s.listener.Close()
 }
 
+// 优雅关闭
+// 停止监听，通知所有的session处理完已经收到的信息，发送完队列中的数据，然后等待所有session关闭
+// ctx 到期之后，强制关闭剩下的session，不再等待直接返回ctx的错误
+// 阻塞在 OnRecv 中的回调不会被打断，返回之后还可能在执行
+func (s *defaultLibServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.shutdownFlag = true
+	s.mutex.Unlock()
+
+	s.stop()
+	for _, sess := range s.liveSessions() {
+		sess.shutdown()
+	}
+
+	done := make(chan bool)
+	go func() {
+		s.sessionWait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.closeReactor()
+		return nil
+	case <-ctx.Done():
+		for _, sess := range s.liveSessions() {
+			sess.forceClose()
+		}
+		s.closeReactor()
+		return ctx.Err()
+	}
+}
+
+// 服务自己的session，复制一份，关闭的时候不持有锁
+func (s *defaultLibServer) liveSessions() []serverSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := make([]serverSession, 0, len(s.sessions))
+	for sess := range s.sessions {
+		list = append(list, sess)
+	}
+	return list
+}
+
 // 需要异步启动
 func (s *defaultLibServer) Run() {
-
+	if s.serverOption.MetricsName != "" {
+		serverVars.Set(s.serverOption.MetricsName, s.metrics.vars())
+	}
+	if s.socket != nil && s.socket.inherited {
+		s.socket.inherited = false
+		inheritedRunning()
+	}
 	for i := 0; i < s.serverOption.Workers; i++ {
 		go s.run()
 	}
@@ -49,14 +162,21 @@ func (s *defaultLibServer) run() {
 	// 接收监听的连接
 	var delay time.Duration
 	for {
-		// 接收监听
-
-		if s.serverOption.MaxConn > 0 && s.serverOption.MaxConn < s.connCount.Get() {
-			// 超过最大连接，等待
-			continue
+		// 超过最大连接的时候，等待有连接关闭，没有连接的请求留在系统的队列中
+		if !s.acquire() {
+			return
 		}
+		// 接收监听
 		conn, err := s.listener.Accept()
 		if err != nil {
+			s.releaseSlot()
+			if re, ok := err.(*RejectError); ok {
+				// 单个连接的错误，例如PROXY protocol的包头不合法
+				if onError := s.handler.onError(); onError != nil {
+					onError(re)
+				}
+				continue
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if delay == 0 {
 					delay = 5 * time.Millisecond
@@ -70,31 +190,158 @@ func (s *defaultLibServer) run() {
 				continue
 			}
 			if strings.Contains(err.Error(), "use of closed network connection") {
-				if ServerErrorBlock != nil {
-					ServerErrorBlock(io.EOF)
+				if onError := s.handler.onError(); onError != nil {
+					onError(io.EOF)
 				}
 
 				return
 			}
 
-			if ServerErrorBlock != nil {
-				ServerErrorBlock(err)
+			if onError := s.handler.onError(); onError != nil {
+				onError(err)
 			}
 
 			return
 		}
 
-		session := newDefaultSession(conn, s.sessionOption)
+		delay = 0
 
-		if ServerSessionBlock != nil {
-			ServerSessionBlock(session)
+		if err := s.admit(conn); err != nil {
+			s.releaseSlot()
+			conn.Close()
+			if onError := s.handler.onError(); onError != nil {
+				onError(&RejectError{Addr: conn.RemoteAddr(), Err: err})
+			}
+			continue
 		}
-		session.onClose = func(sess Session2Interface) {
-			s.connCount.DecrementAndGet()
+
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			// 握手放到单独的协程，慢的客户端不会卡住监听
+			go s.handshake(tlsConn)
+			continue
 		}
-		session.onError = SessionErrorBlock
-		session.onRecv = SessionRecvBlock
-		session.Accept()
-		s.connCount.IncrementAndGet()
+		s.serve(conn)
 	}
 }
+
+// tls握手，握手之后session中就可以拿到对端的证书
+func (s *defaultLibServer) handshake(conn *tls.Conn) {
+	if s.serverOption.TLSHandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.serverOption.TLSHandshakeTimeout))
+	}
+	if err := conn.Handshake(); err != nil {
+		s.release(conn)
+		conn.Close()
+		if onError := s.handler.onError(); onError != nil {
+			onError(err)
+		}
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	s.serve(conn)
+}
+
+// 为连接新建session
+func (s *defaultLibServer) serve(conn net.Conn) {
+	session, err := s.newSession(conn)
+	if err != nil {
+		s.release(conn)
+		conn.Close()
+		if onError := s.handler.onError(); onError != nil {
+			onError(err)
+		}
+		return
+	}
+	session.stats().parent = s.metrics
+	if !s.addSession(session) {
+		// 已经在关闭了
+		s.release(conn)
+		conn.Close()
+		return
+	}
+
+	if onSession := s.handler.onSession(); onSession != nil {
+		onSession(session)
+	}
+	session.setCloseHook(func(sess Session2Interface) {
+		s.connCount.DecrementAndGet()
+		s.release(conn)
+		s.delSession(session)
+	})
+	session.Accept()
+	s.connCount.IncrementAndGet()
+}
+
+// 取一个连接的名额，服务关闭的时候返回false
+func (s *defaultLibServer) acquire() bool {
+	if s.connSlots == nil {
+		return true
+	}
+	select {
+	case s.connSlots <- true:
+		return true
+	case <-s.closeChan:
+		return false
+	}
+}
+
+func (s *defaultLibServer) releaseSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// 按顺序执行准入检查，拒绝的时候释放前面已经通过的
+func (s *defaultLibServer) admit(conn net.Conn) error {
+	filters := s.serverOption.AcceptFilters
+	for i, filter := range filters {
+		if err := filter.Allow(conn); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				filters[j].Release(conn)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// 连接结束，归还名额
+func (s *defaultLibServer) release(conn net.Conn) {
+	for _, filter := range s.serverOption.AcceptFilters {
+		filter.Release(conn)
+	}
+	s.releaseSlot()
+}
+
+func (s *defaultLibServer) newSession(conn net.Conn) (serverSession, error) {
+	if s.reactor != nil {
+		return s.reactor.newSession(conn, s.sessionOption, s.handler)
+	}
+	return newDefaultSession(conn, s.sessionOption, s.handler), nil
+}
+
+func (s *defaultLibServer) closeReactor() {
+	if s.reactor != nil {
+		s.reactor.close()
+	}
+}
+
+func (s *defaultLibServer) addSession(sess serverSession) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.shutdownFlag {
+		return false
+	}
+	s.sessions[sess] = true
+	s.hub.Add(sess)
+	s.sessionWait.Add(1)
+	return true
+}
+
+func (s *defaultLibServer) delSession(sess serverSession) {
+	s.mutex.Lock()
+	delete(s.sessions, sess)
+	s.mutex.Unlock()
+	s.hub.Del(sess)
+	s.sessionWait.Done()
+}
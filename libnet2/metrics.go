@@ -0,0 +1,200 @@
+package libnet2
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/wuqifei/server_lib/perf"
+)
+
+// 发布到expvar的名字，通过 perf.MonitorOn 的 /debug/vars 查看
+const metricsName = "libnet2"
+
+// 每个服务的统计，设置了 NetOption.MetricsName 的服务在运行的时候发布到这里
+var serverVars = expvar.NewMap(metricsName + "_servers")
+
+// 统计的快照
+type Stats struct {
+	// 存活的session
+	LiveConns int64
+	// 建立和关闭的session总数
+	Accepted int64
+	Closed   int64
+	// 收发的包体字节数，不包括包头
+	BytesIn  int64
+	BytesOut int64
+	// 收发的包数，心跳包也算在内
+	FramesIn  int64
+	FramesOut int64
+	// 队列中还没处理的条数
+	RecvQueue int
+	SendQueue int
+	// 处理的包数和总耗时
+	Handled    int64
+	HandleTime time.Duration
+	// 开始统计以来平均每秒处理的包数
+	QPS float64
+}
+
+// 一组session的统计，进程、服务或客户端、单个session各一份，session的统计同时累加到上一级
+// 收发的时候只做原子加，QPS 之类在读取的时候再算
+type netMetrics struct {
+	parent *netMetrics
+	start  time.Time
+
+	liveConns  expvar.Int
+	accepted   expvar.Int
+	closed     expvar.Int
+	bytesIn    expvar.Int
+	bytesOut   expvar.Int
+	framesIn   expvar.Int
+	framesOut  expvar.Int
+	handled    expvar.Int
+	handleTime expvar.Int
+	// 处理收到信息的耗时，秒，单个session的统计没有
+	latency *perf.Histogram
+
+	// 存活的session，查看队列长度的时候遍历
+	sessions sync.Map
+}
+
+// 进程中所有session的统计，客户端的session也算在内
+var metrics *netMetrics
+
+func init() {
+	metrics = newNetMetrics(nil)
+	metrics.latency = perf.NewHistogram(nil)
+	expvar.Publish(metricsName, metrics.vars())
+}
+
+func newNetMetrics(parent *netMetrics) *netMetrics {
+	m := new(netMetrics)
+	m.parent = parent
+	m.start = time.Now()
+	return m
+}
+
+// 服务和客户端的统计，带延迟分布
+func newGroupMetrics() *netMetrics {
+	m := newNetMetrics(metrics)
+	m.latency = perf.NewHistogram(nil)
+	return m
+}
+
+// 发布到expvar的内容
+func (m *netMetrics) vars() *expvar.Map {
+	vars := new(expvar.Map).Init()
+	vars.Set("live_conns", &m.liveConns)
+	vars.Set("accepted_total", &m.accepted)
+	vars.Set("closed_total", &m.closed)
+	vars.Set("bytes_in", &m.bytesIn)
+	vars.Set("bytes_out", &m.bytesOut)
+	vars.Set("frames_in", &m.framesIn)
+	vars.Set("frames_out", &m.framesOut)
+	vars.Set("handled_total", &m.handled)
+	vars.Set("recv_queue_depth", expvar.Func(func() interface{} { return m.queueDepth(QueueRecv) }))
+	vars.Set("send_queue_depth", expvar.Func(func() interface{} { return m.queueDepth(QueueSend) }))
+	vars.Set("qps", expvar.Func(func() interface{} { return m.snapshot().QPS }))
+	if m.latency != nil {
+		vars.Set("handler_latency", m.latency)
+	}
+	return vars
+}
+
+func (m *netMetrics) open(sess Session2Interface) {
+	ds, queued := sess.(*defaultSession)
+	for ; m != nil; m = m.parent {
+		if queued {
+			// reactor模式的session没有队列
+			m.sessions.Store(ds, true)
+		}
+		m.liveConns.Add(1)
+		m.accepted.Add(1)
+	}
+	perf.AddTotalConn(1)
+}
+
+func (m *netMetrics) close(sess Session2Interface) {
+	for ; m != nil; m = m.parent {
+		m.sessions.Delete(sess)
+		m.liveConns.Add(-1)
+		m.closed.Add(1)
+	}
+	perf.AddTotalConn(-1)
+}
+
+func (m *netMetrics) recv(size int) {
+	for ; m != nil; m = m.parent {
+		m.framesIn.Add(1)
+		m.bytesIn.Add(int64(size))
+	}
+}
+
+func (m *netMetrics) send(size int) {
+	for ; m != nil; m = m.parent {
+		m.framesOut.Add(1)
+		m.bytesOut.Add(int64(size))
+	}
+}
+
+func (m *netMetrics) handle(cost time.Duration) {
+	seconds := cost.Seconds()
+	for ; m != nil; m = m.parent {
+		m.handled.Add(1)
+		m.handleTime.Add(int64(cost))
+		if m.latency != nil {
+			m.latency.Observe(seconds)
+		}
+	}
+	perf.AddHandleTime(seconds)
+}
+
+// session中队列还没处理的总数
+func (m *netMetrics) queueDepth(queue QueueType) int {
+	total := 0
+	m.sessions.Range(func(key, val interface{}) bool {
+		ch, _, _, _ := key.(*defaultSession).queue(queue)
+		total += len(ch)
+		return true
+	})
+	return total
+}
+
+func (m *netMetrics) snapshot() Stats {
+	var stats Stats
+	stats.LiveConns = m.liveConns.Value()
+	stats.Accepted = m.accepted.Value()
+	stats.Closed = m.closed.Value()
+	stats.BytesIn = m.bytesIn.Value()
+	stats.BytesOut = m.bytesOut.Value()
+	stats.FramesIn = m.framesIn.Value()
+	stats.FramesOut = m.framesOut.Value()
+	stats.RecvQueue = m.queueDepth(QueueRecv)
+	stats.SendQueue = m.queueDepth(QueueSend)
+	stats.Handled = m.handled.Value()
+	stats.HandleTime = time.Duration(m.handleTime.Value())
+	if elapsed := time.Since(m.start).Seconds(); elapsed > 0 {
+		stats.QPS = float64(stats.Handled) / elapsed
+	}
+	return stats
+}
+
+// 带有统计的session
+type statsSession interface {
+	stats() *netMetrics
+}
+
+// session的统计，客户端返回所有重连的累计
+func SessionStats(sess Session2Interface) (Stats, error) {
+	ss, ok := sess.(statsSession)
+	if !ok {
+		return Stats{}, ErrStatsUnsupported
+	}
+	return ss.stats().snapshot(), nil
+}
+
+// 进程中所有session的统计
+func GlobalStats() Stats {
+	return metrics.snapshot()
+}
@@ -0,0 +1,89 @@
+package libnet2_test
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libnet2"
+	"github.com/wuqifei/server_lib/libnet2/nettest"
+)
+
+// 等待统计满足条件，写出的统计在写完之后才加，对端收到的时候可能还没加上
+func waitStats(t *testing.T, stats func() libnet2.Stats, ok func(libnet2.Stats) bool) libnet2.Stats {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		s := stats()
+		if ok(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	packet := libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024)
+	option := libnet2.DefaultOption()
+	option.MetricsName = "stats-test"
+	handler := new(libnet2.Handler)
+	handler.OnRecv = func(sess libnet2.Session2Interface, val []byte) {
+		sess.Send(append([]byte(nil), val...))
+	}
+	a, err := nettest.NewServerWithOption(packet, option, nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b := newEchoServer(t, packet)
+	defer b.Close()
+
+	c, err := a.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Expect([]byte("hello"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	stats := waitStats(t, a.Server.Stats, func(s libnet2.Stats) bool {
+		return s.FramesOut == 1
+	})
+	if stats.Accepted != 1 || stats.LiveConns != 1 || stats.FramesIn != 1 || stats.BytesIn != 5 || stats.BytesOut != 5 || stats.Handled != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// 另一个服务的统计不受影响
+	if stats := b.Server.Stats(); stats.Accepted != 0 || stats.FramesIn != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	e, err := a.Recorder.Wait(nettest.EventSession, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := libnet2.SessionStats(e.Sess); err != nil || stats.FramesIn != 1 || stats.FramesOut != 1 {
+		t.Fatalf("unexpected session stats %+v %v", stats, err)
+	}
+	if _, err = libnet2.SessionStats(foreignSession{}); err != libnet2.ErrStatsUnsupported {
+		t.Fatalf("expected stats unsupported, got %v", err)
+	}
+
+	servers := expvar.Get("libnet2_servers").(*expvar.Map)
+	if servers.Get("stats-test") == nil {
+		t.Fatal("server metrics not published")
+	}
+
+	c.Close()
+	waitStats(t, a.Server.Stats, func(s libnet2.Stats) bool {
+		return s.LiveConns == 0 && s.Closed == 1
+	})
+	a.Close()
+	if servers.Get("stats-test") != nil {
+		t.Fatal("server metrics not removed after close")
+	}
+}
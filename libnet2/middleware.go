@@ -0,0 +1,57 @@
+package libnet2
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// 捕获处理函数中的panic，onPanic为nil的时候打印堆栈并关闭session
+func Recovery(onPanic func(ctx *Context, err interface{})) RouteFunc {
+	return func(ctx *Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				ctx.Abort()
+				if onPanic != nil {
+					onPanic(ctx, err)
+					return
+				}
+				buf := make([]byte, 4096)
+				buf = buf[:runtime.Stack(buf, false)]
+				fmt.Printf("libnet2:session [%d] cmd [%d] panic:%v\n%s\n", ctx.Session.GetUniqueID(), ctx.Cmd, err, buf)
+				ctx.Session.Close()
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// 打印每次处理的命令号和耗时，output为nil的时候使用fmt.Printf
+func Logger(output func(format string, v ...interface{})) RouteFunc {
+	if output == nil {
+		output = func(format string, v ...interface{}) {
+			fmt.Printf(format+"\n", v...)
+		}
+	}
+	return func(ctx *Context) {
+		start := time.Now()
+		ctx.Next()
+		output("libnet2:session [%d] cmd [%d] len [%d] cost [%v]", ctx.Session.GetUniqueID(), ctx.Cmd, len(ctx.Body), time.Since(start))
+	}
+}
+
+// 验证，check返回false的时候中断处理
+// skip 里面的命令号不需要验证，比如登录
+func Auth(check func(ctx *Context) bool, skip ...uint32) RouteFunc {
+	skips := make(map[uint32]bool, len(skip))
+	for _, cmd := range skip {
+		skips[cmd] = true
+	}
+	return func(ctx *Context) {
+		if skips[ctx.Cmd] || check(ctx) {
+			ctx.Next()
+			return
+		}
+		ctx.Abort()
+	}
+}
@@ -0,0 +1,62 @@
+package nettest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/wuqifei/server_lib/libio"
+	"github.com/wuqifei/server_lib/libnet2"
+)
+
+// 收到的包和期望的不一样
+type MismatchError struct {
+	Want []byte
+	Got  []byte
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("nettest: want frame %q, got %q", e.Want, e.Got)
+}
+
+// 每次最多读chunk个字节的reader，模拟半包
+type chunkReader struct {
+	r     io.Reader
+	chunk int
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if r.chunk > 0 && len(b) > r.chunk {
+		b = b[:r.chunk]
+	}
+	return r.r.Read(b)
+}
+
+// 测试 PacketInterface 的编解码，frames 编码之后每次只读 readChunk 个字节解码回来
+// 返回解码出来的包，和原来的不一样的时候返回 *MismatchError
+func RoundTrip(packet libnet2.PacketInterface, frames [][]byte, readChunk int) ([][]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := libio.NewWriter(buf)
+	for _, frame := range frames {
+		if err := packet.Write(writer, frame); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := libio.NewReader(&chunkReader{r: buf, chunk: readChunk})
+	decoded := make([][]byte, 0, len(frames))
+	for _, frame := range frames {
+		got, err := packet.Read(reader)
+		if err != nil {
+			return decoded, err
+		}
+		decoded = append(decoded, got)
+		if !bytes.Equal(got, frame) {
+			return decoded, &MismatchError{Want: frame, Got: got}
+		}
+	}
+	if buf.Len() > 0 {
+		return decoded, fmt.Errorf("nettest: %d bytes left after decoding", buf.Len())
+	}
+	return decoded, nil
+}
@@ -0,0 +1,134 @@
+package nettest
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// 注入到连接上的故障，零值表示没有故障
+type Faults struct {
+	// 每次Read最多返回的字节数，模拟半包
+	ReadChunk int
+	// 每次写入拆成多少字节一段，对端会分几次收到
+	WriteChunk int
+	// 每一段写入之前的延迟，模拟慢的对端
+	WriteDelay time.Duration
+	// 写入这么多字节之后重置连接，可以停在一个包的中间，0为不重置
+	ResetAfter int
+}
+
+// 带故障注入的连接，Reset 可以随时重置
+// 重置之后对端读到 io.EOF，自己的读写返回 ErrReset
+type FaultConn struct {
+	net.Conn
+	faults Faults
+	local  net.Addr
+	remote net.Addr
+
+	writeMutex sync.Mutex
+	written    int
+	resetOnce  sync.Once
+	resetChan  chan bool
+}
+
+func newFaultConn(conn net.Conn, faults *Faults, local, remote net.Addr) *FaultConn {
+	c := new(FaultConn)
+	c.Conn = conn
+	if faults != nil {
+		c.faults = *faults
+	}
+	c.local = local
+	c.remote = remote
+	c.resetChan = make(chan bool)
+	return c
+}
+
+// 包装任意的连接，例如真实的tcp连接
+func NewFaultConn(conn net.Conn, faults *Faults) *FaultConn {
+	return newFaultConn(conn, faults, conn.LocalAddr(), conn.RemoteAddr())
+}
+
+func (c *FaultConn) isReset() bool {
+	select {
+	case <-c.resetChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *FaultConn) Read(b []byte) (int, error) {
+	if c.isReset() {
+		return 0, ErrReset
+	}
+	if c.faults.ReadChunk > 0 && len(b) > c.faults.ReadChunk {
+		b = b[:c.faults.ReadChunk]
+	}
+	n, err := c.Conn.Read(b)
+	if err != nil && c.isReset() {
+		err = ErrReset
+	}
+	return n, err
+}
+
+func (c *FaultConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	total := 0
+	for len(b) > 0 {
+		if c.isReset() {
+			return total, ErrReset
+		}
+		chunk := b
+		if c.faults.WriteChunk > 0 && len(chunk) > c.faults.WriteChunk {
+			chunk = chunk[:c.faults.WriteChunk]
+		}
+		reset := false
+		if c.faults.ResetAfter > 0 && c.written+len(chunk) >= c.faults.ResetAfter {
+			chunk = chunk[:c.faults.ResetAfter-c.written]
+			reset = true
+		}
+		if c.faults.WriteDelay > 0 {
+			time.Sleep(c.faults.WriteDelay)
+		}
+		n, err := c.Conn.Write(chunk)
+		total += n
+		c.written += n
+		if err != nil {
+			if c.isReset() {
+				err = ErrReset
+			}
+			return total, err
+		}
+		if reset {
+			c.Reset()
+			return total, ErrReset
+		}
+		b = b[n:]
+	}
+	return total, nil
+}
+
+// 重置连接，没有发完的数据直接丢弃
+// tcp连接设置 linger 为0，对端会收到RST
+func (c *FaultConn) Reset() error {
+	var err error
+	c.resetOnce.Do(func() {
+		close(c.resetChan)
+		if tc, ok := c.Conn.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+func (c *FaultConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *FaultConn) RemoteAddr() net.Addr {
+	return c.remote
+}
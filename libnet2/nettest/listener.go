@@ -0,0 +1,90 @@
+// 测试libnet2服务的辅助工具，不需要监听真实的端口
+// 服务运行在内存的监听上，每个连接是一对 net.Pipe，可以注入半包，慢写和连接重置之类的故障
+package nettest
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/wuqifei/server_lib/concurrent"
+)
+
+var (
+	// 监听已经关闭，和net包关闭的错误信息一致，服务按照这个结束接收的循环
+	ErrListenerClosed = errors.New("nettest: use of closed network connection")
+	// 连接被 Reset 重置
+	ErrReset = errors.New("nettest: connection reset")
+	// 等待超时
+	ErrTimeout = errors.New("nettest: timeout")
+)
+
+// 内存中的地址，每个连接不一样
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
+
+// 连接的序号，用来生成地址
+var pipeSeq = concurrent.NewAtomicInt64(0)
+
+// 内存中的监听，Dial 的时候生成一对 net.Pipe，服务端的一端从 Accept 返回
+type Listener struct {
+	connChan  chan net.Conn
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+func NewListener() *Listener {
+	l := new(Listener)
+	l.connChan = make(chan net.Conn)
+	l.closeChan = make(chan bool)
+	return l
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connChan:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return pipeAddr("pipe-listener")
+}
+
+// 建立一个连接，等到服务 Accept 之后返回
+func (l *Listener) Dial() (*FaultConn, error) {
+	return l.DialFaults(nil, nil)
+}
+
+// 建立一个连接，client和server分别是客户端和服务端一侧的故障，可以为nil
+func (l *Listener) DialFaults(client, server *Faults) (*FaultConn, error) {
+	seq := strconv.FormatInt(pipeSeq.IncrementAndGet(), 10)
+	c, s := net.Pipe()
+	clientConn := newFaultConn(c, client, pipeAddr("pipe-client-"+seq), l.Addr())
+	serverConn := newFaultConn(s, server, l.Addr(), pipeAddr("pipe-client-"+seq))
+	select {
+	case l.connChan <- serverConn:
+		return clientConn, nil
+	case <-l.closeChan:
+		c.Close()
+		s.Close()
+		return nil, ErrListenerClosed
+	}
+}
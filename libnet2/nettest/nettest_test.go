@@ -0,0 +1,123 @@
+package nettest
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libnet2"
+)
+
+func newEchoServer(t *testing.T) *Server {
+	handler := new(libnet2.Handler)
+	handler.OnRecv = func(sess libnet2.Session2Interface, val []byte) {
+		sess.Send(append([]byte(nil), val...))
+	}
+	s, err := NewServer(libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024), nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServerEcho(t *testing.T) {
+	s := newEchoServer(t)
+	defer s.Close()
+
+	// 服务端每次只读一个字节，客户端每3个字节写一次
+	c, err := s.DialFaults(&Faults{WriteChunk: 3, WriteDelay: time.Millisecond}, &Faults{ReadChunk: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Recorder.Wait(EventSession, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "world", ""} {
+		if err = c.Send([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Expect([]byte(msg), time.Second); err != nil {
+			t.Fatal(err)
+		}
+		e, err := s.Recorder.Wait(EventRecv, time.Second)
+		if err != nil || string(e.Frame) != msg {
+			t.Fatalf("expected recv %q, got %q %v", msg, e.Frame, err)
+		}
+	}
+	if _, err = c.Recv(50 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestServerReset(t *testing.T) {
+	s := newEchoServer(t)
+	defer s.Close()
+
+	// 包头和一半的包体之后重置
+	c, err := s.Dial(&Faults{ResetAfter: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Send([]byte("hello")); err != ErrReset {
+		t.Fatalf("expected reset, got %v", err)
+	}
+	if _, err = s.Recorder.Wait(EventClose, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Recorder.Count(EventRecv); n != 0 {
+		t.Fatalf("expected no frame, got %d", n)
+	}
+
+	// 服务端关闭连接，客户端读到结束
+	c, err = s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第一个是上面重置的连接
+	s.Recorder.Wait(EventSession, time.Second)
+	e, err := s.Recorder.Wait(EventSession, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Sess.Close()
+	if err = c.WaitClose(time.Second); err == nil || err == ErrTimeout {
+		t.Fatalf("expected closed, got %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	frames := [][]byte{[]byte("a"), {}, bytes.Repeat([]byte("x"), 300), []byte("end")}
+	packets := []libnet2.PacketInterface{
+		libnet2.NewLengthPacket(4, libnet2.LittleEndian, 1024),
+		libnet2.NewUvarintPacket(1024),
+	}
+	for _, packet := range packets {
+		for _, chunk := range []int{0, 1, 7} {
+			if _, err := RoundTrip(packet, frames, chunk); err != nil {
+				t.Fatalf("%T chunk %d: %v", packet, chunk, err)
+			}
+		}
+	}
+
+	// 分隔符的包体不能包含分隔符，写入的时候就拒绝
+	_, err := RoundTrip(libnet2.NewLinePacket(64), [][]byte{[]byte("a\nb")}, 1)
+	if err != libnet2.ErrDelimiterInFrame {
+		t.Fatalf("expected delimiter error, got %v", err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	s := newEchoServer(t)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 和关闭真实的监听一样，接收的循环按正常结束处理
+	e, err := s.Recorder.Wait(EventError, time.Second)
+	if err != nil || e.Err != io.EOF {
+		t.Fatalf("expected EOF, got %v %v", e.Err, err)
+	}
+	if _, err = s.Dial(); err != ErrListenerClosed {
+		t.Fatalf("expected listener closed, got %v", err)
+	}
+}
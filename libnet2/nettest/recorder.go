@@ -0,0 +1,152 @@
+package nettest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wuqifei/server_lib/libnet2"
+)
+
+// 回调的类型
+type EventKind int
+
+const (
+	// Handler.OnSession
+	EventSession EventKind = iota
+	// Handler.OnRecv
+	EventRecv
+	// Handler.OnClose
+	EventClose
+	// Handler.OnSessError
+	EventSessError
+	// Handler.OnError
+	EventError
+)
+
+// 一次回调
+type Event struct {
+	Kind EventKind
+	Sess libnet2.Session2Interface
+	// EventRecv 收到的包，复制过的
+	Frame []byte
+	// EventSessError 和 EventError 的错误
+	Err error
+}
+
+// 记录服务的回调，测试中按顺序等待
+type Recorder struct {
+	mutex  sync.Mutex
+	events []Event
+	// 每一种回调已经被 Wait 取走的位置
+	cursor map[EventKind]int
+	// 有新的回调的时候关闭，然后换一个新的
+	notify chan bool
+}
+
+func NewRecorder() *Recorder {
+	r := new(Recorder)
+	r.cursor = make(map[EventKind]int)
+	r.notify = make(chan bool)
+	return r
+}
+
+func (r *Recorder) add(e Event) {
+	r.mutex.Lock()
+	r.events = append(r.events, e)
+	close(r.notify)
+	r.notify = make(chan bool)
+	r.mutex.Unlock()
+}
+
+// 包装handler，先记录，再执行原来的回调
+// h 为nil的时候只记录，包的解析使用 packet
+func (r *Recorder) Wrap(h *libnet2.Handler, packet libnet2.PacketInterface) *libnet2.Handler {
+	wrapped := libnet2.NewHandler(packet)
+	if h != nil {
+		*wrapped = *h
+		if wrapped.Packet == nil {
+			wrapped.Packet = packet
+		}
+	}
+	onSession, onRecv, onClose := wrapped.OnSession, wrapped.OnRecv, wrapped.OnClose
+	onSessError, onError := wrapped.OnSessError, wrapped.OnError
+
+	wrapped.OnSession = func(sess libnet2.Session2Interface) {
+		r.add(Event{Kind: EventSession, Sess: sess})
+		if onSession != nil {
+			onSession(sess)
+		}
+	}
+	wrapped.OnRecv = func(sess libnet2.Session2Interface, val []byte) {
+		frame := append([]byte(nil), val...)
+		r.add(Event{Kind: EventRecv, Sess: sess, Frame: frame})
+		if onRecv != nil {
+			onRecv(sess, val)
+		}
+	}
+	wrapped.OnClose = func(sess libnet2.Session2Interface) {
+		r.add(Event{Kind: EventClose, Sess: sess})
+		if onClose != nil {
+			onClose(sess)
+		}
+	}
+	wrapped.OnSessError = func(sess libnet2.Session2Interface, err error) {
+		r.add(Event{Kind: EventSessError, Sess: sess, Err: err})
+		if onSessError != nil {
+			onSessError(sess, err)
+		}
+	}
+	wrapped.OnError = func(err error) {
+		r.add(Event{Kind: EventError, Err: err})
+		if onError != nil {
+			onError(err)
+		}
+	}
+	return wrapped
+}
+
+// 等待下一个这种类型的回调，每个回调只会返回一次
+func (r *Recorder) Wait(kind EventKind, timeout time.Duration) (Event, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		r.mutex.Lock()
+		for i := r.cursor[kind]; i < len(r.events); i++ {
+			if r.events[i].Kind == kind {
+				r.cursor[kind] = i + 1
+				e := r.events[i]
+				r.mutex.Unlock()
+				return e, nil
+			}
+		}
+		r.cursor[kind] = len(r.events)
+		notify := r.notify
+		r.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return Event{}, ErrTimeout
+		}
+	}
+}
+
+// 到目前为止所有的回调
+func (r *Recorder) Events() []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// 某一种回调的次数
+func (r *Recorder) Count(kind EventKind) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	count := 0
+	for _, e := range r.events {
+		if e.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
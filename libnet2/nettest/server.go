@@ -0,0 +1,168 @@
+package nettest
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+	"github.com/wuqifei/server_lib/libnet2"
+)
+
+// 关闭服务的时候，等待session优雅关闭的时间
+const shutdownTimeout = 5 * time.Second
+
+// 运行在内存监听上的服务，回调都会记录在 Recorder 中
+type Server struct {
+	Listener *Listener
+	Server   libnet2.LibserverInterface
+	Recorder *Recorder
+
+	packet libnet2.PacketInterface
+}
+
+// 新建并且启动服务，handler 可以为nil，sessionOption 为nil的时候使用默认的配置
+func NewServer(packet libnet2.PacketInterface, sessionOption *libnet2.SessionOption2, handler *libnet2.Handler) (*Server, error) {
+	return NewServerWithOption(packet, libnet2.DefaultOption(), sessionOption, handler)
+}
+
+// 使用服务的配置新建，例如 MaxConn 和 AcceptFilters，Network 和 Address 不会使用
+func NewServerWithOption(packet libnet2.PacketInterface, option *libnet2.NetOption, sessionOption *libnet2.SessionOption2, handler *libnet2.Handler) (*Server, error) {
+	if sessionOption == nil {
+		sessionOption = libnet2.DefaultSessionOption()
+	}
+	s := new(Server)
+	s.Listener = NewListener()
+	s.Recorder = NewRecorder()
+	s.packet = packet
+	server, err := libnet2.NewWithListener(s.Listener, option, sessionOption, s.Recorder.Wrap(handler, packet))
+	if err != nil {
+		return nil, err
+	}
+	s.Server = server
+	server.Run()
+	return s, nil
+}
+
+// 连接服务，faults 为客户端一侧的故障，可以不传
+func (s *Server) Dial(faults ...*Faults) (*Client, error) {
+	var f *Faults
+	if len(faults) > 0 {
+		f = faults[0]
+	}
+	return s.DialFaults(f, nil)
+}
+
+// 连接服务，client和server分别是客户端和服务端一侧的故障
+func (s *Server) DialFaults(client, server *Faults) (*Client, error) {
+	conn, err := s.Listener.DialFaults(client, server)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, s.packet), nil
+}
+
+// 优雅关闭服务，超时之后强制关闭
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.Server.Shutdown(ctx)
+}
+
+// 按脚本收发包的客户端，收到的包在后台读出来排队
+type Client struct {
+	Conn *FaultConn
+
+	packet    libnet2.PacketInterface
+	writer    *libio.Writer
+	frameChan chan []byte
+	// 读取结束的错误，frameChan 关闭之后可以读取
+	err error
+}
+
+func NewClient(conn *FaultConn, packet libnet2.PacketInterface) *Client {
+	c := new(Client)
+	c.Conn = conn
+	c.packet = packet
+	c.writer = libio.NewWriter(conn)
+	c.frameChan = make(chan []byte, 64)
+	go c.readLoop()
+	return c
+}
+
+func (c *Client) readLoop() {
+	defer close(c.frameChan)
+	reader := libio.NewReader(c.Conn)
+	for {
+		frame, err := c.packet.Read(reader)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.frameChan <- frame
+	}
+}
+
+// 发送一个包
+func (c *Client) Send(frame []byte) error {
+	return c.packet.Write(c.writer, frame)
+}
+
+// 发送原始的字节，例如不合法的包
+func (c *Client) SendRaw(b []byte) error {
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+// 等待服务发过来的下一个包，连接关闭之后返回读取的错误
+func (c *Client) Recv(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case frame, ok := <-c.frameChan:
+		if !ok {
+			return nil, c.err
+		}
+		return frame, nil
+	case <-timer.C:
+		return nil, ErrTimeout
+	}
+}
+
+// 等待下一个包，内容不一样的时候返回 *MismatchError
+func (c *Client) Expect(want []byte, timeout time.Duration) error {
+	frame, err := c.Recv(timeout)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(frame, want) {
+		return &MismatchError{Want: want, Got: frame}
+	}
+	return nil
+}
+
+// 等待服务关闭连接，返回读取结束的错误，通常是 io.EOF
+// 关闭之前收到的包会被丢弃
+func (c *Client) WaitClose(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-c.frameChan:
+			if !ok {
+				return c.err
+			}
+		case <-timer.C:
+			return ErrTimeout
+		}
+	}
+}
+
+// 重置连接，服务端读到 io.EOF
+func (c *Client) Reset() error {
+	return c.Conn.Reset()
+}
+
+func (c *Client) Close() error {
+	return c.Conn.Close()
+}
@@ -1,12 +1,22 @@
 package libnet2
 
-import "time"
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+)
 
 // 连接的配置
 type NetOption struct {
-	// 最大的连接数目
+	// 最大的连接数目，达到之后暂停Accept，有连接关闭之后再继续
 	MaxConn int32
 
+	// 连接的准入检查，按顺序执行，例如 NewConnLimiter，NewRateFilter，NewIPFilter
+	AcceptFilters []AcceptFilter
+
 	// 网络类型
 	Network string
 
@@ -15,6 +25,37 @@ type NetOption struct {
 
 	// 多少个核心
 	Workers int
+
+	// 大于0的时候使用epoll的reactor模式，每个连接不再需要单独的协程，只支持linux下没有tls的tcp
+	// 收到的信息在循环中回调，OnRecv 中不能阻塞
+	// SessionOption2 中只有 ReadTimeout，ReadTimeoutTimes，RecvRate，FramePool，SendChanSize，SendPolicy 生效
+	// 发送不会阻塞，SendChanSize 是发送缓冲中最多的包数，超过之后 OverflowBlock 和 OverflowDropNewest 一样返回 ErrQueueFull
+	ReactorLoops int
+
+	// tls的配置，为nil的时候不加密，可以用NewTLSConfig创建
+	TLSConfig *tls.Config
+	// tls握手的超时，0为不超时
+	TLSHandshakeTimeout time.Duration
+
+	// 在负载均衡后面的时候，解析连接开头的PROXY protocol v1/v2包头，只对tcp生效
+	// 开启之后 RemoteAddr 以及准入检查都使用客户端真实的地址，没有包头的连接会被拒绝
+	// 同时解析包头的连接数不超过 MaxConn，解析完之后服务的连接满了的时候关闭
+	ProxyProtocol bool
+	// 读取包头的超时，0为不超时
+	ProxyHeaderTimeout time.Duration
+
+	// Network 为 NetworkWebSocket 的时候，升级的http路径，默认为 /
+	WebSocketPath string
+	// 检查websocket的origin，为nil的时候全部允许
+	WebSocketCheckOrigin func(r *http.Request) bool
+	// 读取http请求头和升级握手的超时，0为不超时
+	WebSocketHandshakeTimeout time.Duration
+
+	// Network 为 udp 的时候，不为nil则使用可靠有序的udp
+	ARQ *ARQOption
+
+	// 不为空的时候，服务运行期间的统计发布到expvar的 libnet2_servers 中，以这个名字为key
+	MetricsName string
 }
 
 // session 的配置
@@ -25,4 +66,101 @@ type SessionOption2 struct {
 
 	RecvChanSize int //接收和发送队列的大小
 	SendChanSize int
+
+	// 空闲的检查，触发 Handler.OnIdle，0为不检查
+	// 读空闲，一段时间没有收到数据
+	ReadIdle time.Duration
+	// 写空闲，一段时间没有写出数据
+	WriteIdle time.Duration
+	// 读写都空闲
+	AllIdle time.Duration
+
+	// 心跳包，为nil的时候不处理心跳
+	Heartbeat HeartbeatInterface
+	// 超过这个时间没有写出数据的时候，发送心跳包，0为只回复不主动发送
+	// 心跳包放入发送队列，队列满的时候不发送，触发 IdleWrite，没有发送队列的时候直接写入连接
+	HeartbeatInterval time.Duration
+
+	// 发送和接收队列满的时候的处理，默认为阻塞
+	SendPolicy OverflowPolicy
+	RecvPolicy OverflowPolicy
+	// 阻塞的超时，0为一直阻塞
+	SendTimeout time.Duration
+	RecvTimeout time.Duration
+
+	// 队列的水位，达到高水位和回落到低水位的时候触发 Handler 的回调，0为不检查
+	// 高水位超过队列容量的时候按容量算，低水位要小于高水位
+	HighWatermark int
+	LowWatermark  int
+
+	// rpc帧的编码，不为nil的时候可以使用 Call，对端的请求交给 Handler.OnCall
+	RPC RPCCodec
+
+	// 每秒最多收到的包数，超过之后关闭session，0为不限制
+	RecvRate float64
+	// 允许突发的包数
+	RecvBurst int
+
+	// 收到的包从缓冲池中分配，为nil的时候每个包单独分配
+	// 设置之后，OnRecv 返回时包会放回池中，需要保留的数据要自己复制一份，包括直接 Send 收到的包
+	FramePool *libio.Pool
+	// 发送队列中的包每次最多合并多少个，用一次writev写出，小于等于1的时候逐个写出
+	// 只对流式的连接有效，按消息读写的连接每个包单独写
+	WriteBatch int
+}
+
+// 队列满的时候的处理
+type OverflowPolicy int
+
+const (
+	// 阻塞等待，超时之后返回 ErrQueueTimeout
+	OverflowBlock OverflowPolicy = iota
+	// 丢弃新的数据，返回 ErrQueueFull
+	OverflowDropNewest
+	// 丢弃队列中最早的数据，放入新的数据，多个协程同时放入抢不到位置的时候返回 ErrQueueFull
+	OverflowDropOldest
+	// 断开连接，返回 ErrQueueFull
+	OverflowDisconnect
+)
+
+// 可靠udp的配置
+type ARQOption struct {
+	// 重传的间隔
+	RetransmitInterval time.Duration
+	// 最多重传的次数，超过之后连接关闭
+	RetransmitTimes int
+	// 发送和接收的窗口，没有确认的包达到这个数目的时候，发送阻塞
+	Window int
+}
+
+// 客户端的配置
+type ClientOption struct {
+	// 网络类型
+	Network string
+
+	// 服务器地址和端口，websocket为 ws://host:port/path
+	Address string
+
+	// 连接的超时，0为不超时
+	DialTimeout time.Duration
+
+	// 自定义的连接方式，例如测试中的内存连接，为nil的时候按照 Network 连接
+	Dialer func(option *ClientOption) (net.Conn, error)
+
+	// tls的配置，为nil的时候不加密
+	TLSConfig *tls.Config
+
+	// Network 为 udp 的时候，不为nil则使用可靠有序的udp，需要和服务端一致
+	ARQ *ARQOption
+
+	// 断开之后是否自动重连
+	Reconnect bool
+	// 第一次重连的等待时间
+	ReconnectDelay time.Duration
+	// 每次重连失败，等待时间乘以这个系数
+	ReconnectFactor float64
+	// 最长的等待时间
+	ReconnectMaxDelay time.Duration
+	// 最多重连的次数，小于等于0为不限制
+	ReconnectTimes int
 }
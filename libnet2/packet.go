@@ -0,0 +1,341 @@
+package libnet2
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 包头长度的字节序
+type ByteOrder int
+
+const (
+	// 大端
+	BigEndian ByteOrder = iota
+	// 小端
+	LittleEndian
+)
+
+// 固定包头的策略没有设置最大长度的时候使用的限制
+const DefaultMaxFrameSize = 64 * 1024
+
+// 包的长度超过限制
+// 收到这个错误的时候，流已经没法继续解析，session会被关闭
+type FrameSizeError struct {
+	// 包的长度，分隔符的包为已经读取的长度
+	// 包头中的长度可能超过32位平台上int的范围
+	Size int64
+	// 允许的最大长度
+	Max int
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("libnet2:frame size [%d] exceeds max [%d]", e.Size, e.Max)
+}
+
+// 可以告诉外面包体最大长度的策略，websocket按照这个限制单条消息的大小
+// 没有实现的策略使用 DefaultMaxFrameSize
+type FrameSizeLimiter interface {
+	MaxFrameSize() int
+}
+
+// 可以直接编码到调用方缓冲中的策略，批量写入的时候包头和包体直接写进池中的缓冲，不用再复制一次
+type FrameEncoder interface {
+	// 包体长度为size的时候，编码之后最多的长度
+	FrameLen(size int) int
+	// 编码到dst中，dst的长度不小于 FrameLen，返回写入的长度
+	EncodeFrame(dst, b []byte) (int, error)
+}
+
+// 可以从包头算出整个包长度的策略，reactor模式下包没收完的时候不用反复解析
+type FrameSizer interface {
+	// 从收到的数据开头算出整个包的长度，包括包头，包头还没收完的时候返回0
+	FrameSize(b []byte) (int, error)
+}
+
+// 包的最大长度，websocket的一条消息最多是包头加上包体
+func frameLimit(packet PacketInterface) int64 {
+	if limiter, ok := packet.(FrameSizeLimiter); ok {
+		return int64(limiter.MaxFrameSize()) + libio.MaxVarintLen64
+	}
+	return DefaultMaxFrameSize + libio.MaxVarintLen64
+}
+
+// 固定包头的策略，包头为包体的长度，支持2个字节和4个字节
+type LengthPacket struct {
+	headSize int
+	order    ByteOrder
+	maxSize  int
+}
+
+// 新建固定包头的策略
+// headSize 只能是2或者4，maxSize 小于等于0的时候使用 DefaultMaxFrameSize，同时不会超过包头可以表示的长度
+func NewLengthPacket(headSize int, order ByteOrder, maxSize int) *LengthPacket {
+	if headSize != 2 && headSize != 4 {
+		panic(fmt.Errorf("libnet2:length packet head size must be 2 or 4, got %d", headSize))
+	}
+	p := new(LengthPacket)
+	p.headSize = headSize
+	p.order = order
+	p.maxSize = p.limit(maxSize)
+	return p
+}
+
+// 包头可以表示的最大长度和设置的最大长度取小的
+// 用int64比较，32位平台上int放不下4个字节包头的最大值
+func (p *LengthPacket) limit(maxSize int) int {
+	headMax := int64(1<<16 - 1)
+	if p.headSize == 4 {
+		headMax = 1<<32 - 1
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	if int64(maxSize) > headMax {
+		return int(headMax)
+	}
+	return maxSize
+}
+
+func (p *LengthPacket) MaxFrameSize() int {
+	return p.maxSize
+}
+
+func (p *LengthPacket) Read(r *libio.Reader) ([]byte, error) {
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	// 先按无符号比较，32位平台上转换成int可能变成负数
+	var size uint64
+	switch {
+	case p.headSize == 2 && p.order == BigEndian:
+		size = uint64(r.ReadUint16BE())
+	case p.headSize == 2:
+		size = uint64(r.ReadUint16LE())
+	case p.order == BigEndian:
+		size = uint64(r.ReadUint32BE())
+	default:
+		size = uint64(r.ReadUint32LE())
+	}
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	if size > uint64(p.maxSize) {
+		return nil, &FrameSizeError{Size: int64(size), Max: p.maxSize}
+	}
+	b := r.ReadBytes(int(size))
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (p *LengthPacket) FrameSize(b []byte) (int, error) {
+	if len(b) < p.headSize {
+		return 0, nil
+	}
+	var size uint64
+	switch {
+	case p.headSize == 2 && p.order == BigEndian:
+		size = uint64(libio.GetUint16BE(b))
+	case p.headSize == 2:
+		size = uint64(libio.GetUint16LE(b))
+	case p.order == BigEndian:
+		size = uint64(libio.GetUint32BE(b))
+	default:
+		size = uint64(libio.GetUint32LE(b))
+	}
+	if size > uint64(p.maxSize) {
+		return 0, &FrameSizeError{Size: int64(size), Max: p.maxSize}
+	}
+	return p.headSize + int(size), nil
+}
+
+func (p *LengthPacket) Write(w *libio.Writer, b []byte) error {
+	// 包头和包体一次写入，避免并发发送的时候被打断
+	val := make([]byte, p.FrameLen(len(b)))
+	n, err := p.EncodeFrame(val, b)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(val[:n])
+	return err
+}
+
+func (p *LengthPacket) FrameLen(size int) int {
+	return p.headSize + size
+}
+
+func (p *LengthPacket) EncodeFrame(val, b []byte) (int, error) {
+	if len(b) > p.maxSize {
+		return 0, &FrameSizeError{Size: int64(len(b)), Max: p.maxSize}
+	}
+	switch {
+	case p.headSize == 2 && p.order == BigEndian:
+		libio.PutUint16BE(val, uint16(len(b)))
+	case p.headSize == 2:
+		libio.PutUint16LE(val, uint16(len(b)))
+	case p.order == BigEndian:
+		libio.PutUint32BE(val, uint32(len(b)))
+	default:
+		libio.PutUint32LE(val, uint32(len(b)))
+	}
+	return p.headSize + copy(val[p.headSize:], b), nil
+}
+
+// 变长包头的策略，包头为uvarint编码的包体长度
+type UvarintPacket struct {
+	maxSize int
+}
+
+// 新建变长包头的策略，maxSize 必须大于0
+func NewUvarintPacket(maxSize int) *UvarintPacket {
+	if maxSize <= 0 {
+		panic(fmt.Errorf("libnet2:uvarint packet max size must be positive, got %d", maxSize))
+	}
+	p := new(UvarintPacket)
+	p.maxSize = maxSize
+	return p
+}
+
+func (p *UvarintPacket) MaxFrameSize() int {
+	return p.maxSize
+}
+
+func (p *UvarintPacket) Read(r *libio.Reader) ([]byte, error) {
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	size := r.ReadUvarint()
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	if size > uint64(p.maxSize) {
+		return nil, &FrameSizeError{Size: int64(size), Max: p.maxSize}
+	}
+	b := r.ReadBytes(int(size))
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (p *UvarintPacket) FrameSize(b []byte) (int, error) {
+	size, n := libio.GetUvarint(b)
+	if n == 0 {
+		return 0, nil
+	}
+	if n < 0 || size > math.MaxInt64 {
+		// 超过64位或者int64放不下的长度按最大算
+		size = math.MaxInt64
+	}
+	if size > uint64(p.maxSize) {
+		return 0, &FrameSizeError{Size: int64(size), Max: p.maxSize}
+	}
+	return n + int(size), nil
+}
+
+func (p *UvarintPacket) Write(w *libio.Writer, b []byte) error {
+	val := make([]byte, p.FrameLen(len(b)))
+	n, err := p.EncodeFrame(val, b)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(val[:n])
+	return err
+}
+
+func (p *UvarintPacket) FrameLen(size int) int {
+	return libio.UvarintSize(uint64(size)) + size
+}
+
+func (p *UvarintPacket) EncodeFrame(val, b []byte) (int, error) {
+	if len(b) > p.maxSize {
+		return 0, &FrameSizeError{Size: int64(len(b)), Max: p.maxSize}
+	}
+	n := libio.PutUvarint(val, uint64(len(b)))
+	return n + copy(val[n:], b), nil
+}
+
+// 分隔符的策略，收到的包不包含分隔符
+// 发送的包中有分隔符的时候返回 ErrDelimiterInFrame，按行分割的时候结尾也不能是\r
+type DelimiterPacket struct {
+	delim   byte
+	trimCR  bool
+	maxSize int
+}
+
+// 新建分隔符的策略，maxSize 必须大于0，不包含分隔符
+func NewDelimiterPacket(delim byte, maxSize int) *DelimiterPacket {
+	if maxSize <= 0 {
+		panic(fmt.Errorf("libnet2:delimiter packet max size must be positive, got %d", maxSize))
+	}
+	p := new(DelimiterPacket)
+	p.delim = delim
+	p.maxSize = maxSize
+	return p
+}
+
+// 按行分割，兼容\r\n
+func NewLinePacket(maxSize int) *DelimiterPacket {
+	p := NewDelimiterPacket('\n', maxSize)
+	p.trimCR = true
+	return p
+}
+
+func (p *DelimiterPacket) MaxFrameSize() int {
+	return p.maxSize
+}
+
+func (p *DelimiterPacket) Read(r *libio.Reader) ([]byte, error) {
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == p.delim {
+			break
+		}
+		if buf.Len() >= p.maxSize {
+			return nil, &FrameSizeError{Size: int64(buf.Len() + 1), Max: p.maxSize}
+		}
+		buf.WriteByte(c)
+	}
+	b := buf.Bytes()
+	if p.trimCR && len(b) > 0 && b[len(b)-1] == '\r' {
+		b = b[:len(b)-1]
+	}
+	return b, nil
+}
+
+func (p *DelimiterPacket) Write(w *libio.Writer, b []byte) error {
+	val := make([]byte, p.FrameLen(len(b)))
+	n, err := p.EncodeFrame(val, b)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(val[:n])
+	return err
+}
+
+func (p *DelimiterPacket) FrameLen(size int) int {
+	return size + 1
+}
+
+func (p *DelimiterPacket) EncodeFrame(val, b []byte) (int, error) {
+	if len(b) > p.maxSize {
+		return 0, &FrameSizeError{Size: int64(len(b)), Max: p.maxSize}
+	}
+	if bytes.IndexByte(b, p.delim) >= 0 || (p.trimCR && len(b) > 0 && b[len(b)-1] == '\r') {
+		return 0, ErrDelimiterInFrame
+	}
+	n := copy(val, b)
+	val[n] = p.delim
+	return n + 1, nil
+}
@@ -0,0 +1,93 @@
+package libnet2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	packets := map[string]PacketInterface{
+		"uint16be": NewLengthPacket(2, BigEndian, 0),
+		"uint16le": NewLengthPacket(2, LittleEndian, 0),
+		"uint32be": NewLengthPacket(4, BigEndian, 1024),
+		"uint32le": NewLengthPacket(4, LittleEndian, 1024),
+		"uvarint":  NewUvarintPacket(1024),
+		"line":     NewLinePacket(1024),
+	}
+	frames := [][]byte{[]byte("hello"), []byte(""), bytes.Repeat([]byte("x"), 300)}
+
+	for name, packet := range packets {
+		buf := new(bytes.Buffer)
+		w := libio.NewWriter(buf)
+		for _, frame := range frames {
+			if err := packet.Write(w, frame); err != nil {
+				t.Fatalf("%s: write error %v", name, err)
+			}
+		}
+		r := libio.NewReader(buf)
+		for _, frame := range frames {
+			val, err := packet.Read(r)
+			if err != nil {
+				t.Fatalf("%s: read error %v", name, err)
+			}
+			if !bytes.Equal(val, frame) {
+				t.Fatalf("%s: expected %q, got %q", name, frame, val)
+			}
+		}
+	}
+}
+
+func TestPacketFrameTooLarge(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := libio.NewWriter(buf)
+	// 直接写一个很大的包头，不应该按照包头去分配内存
+	w.WriteUint32BE(1 << 30)
+	_, err := NewLengthPacket(4, BigEndian, 1024).Read(libio.NewReader(buf))
+	if e, ok := err.(*FrameSizeError); !ok || e.Size != 1<<30 || e.Max != 1024 {
+		t.Fatalf("expected frame size error, got %v", err)
+	}
+
+	// 没有设置最大长度的时候使用默认的限制，而不是包头可以表示的4G
+	buf.Reset()
+	w.WriteUint32BE(DefaultMaxFrameSize + 1)
+	_, err = NewLengthPacket(4, BigEndian, 0).Read(libio.NewReader(buf))
+	if e, ok := err.(*FrameSizeError); !ok || e.Max != DefaultMaxFrameSize {
+		t.Fatalf("expected default frame size error, got %v", err)
+	}
+
+	err = NewUvarintPacket(4).Write(w, []byte("hello"))
+	if _, ok := err.(*FrameSizeError); !ok {
+		t.Fatalf("expected frame size error, got %v", err)
+	}
+
+	_, err = NewLinePacket(4).Read(libio.NewReader(bytes.NewBufferString("hello\n")))
+	if _, ok := err.(*FrameSizeError); !ok {
+		t.Fatalf("expected frame size error, got %v", err)
+	}
+}
+
+func TestDelimiterInFrame(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := libio.NewWriter(buf)
+	// 包中间的分隔符会让对端拆成两个包，结尾的\r会被按行读取去掉
+	for _, frame := range []string{"a\nb", "a\n", "a\r"} {
+		if err := NewLinePacket(16).Write(w, []byte(frame)); err != ErrDelimiterInFrame {
+			t.Fatalf("%q: expected delimiter error, got %v", frame, err)
+		}
+	}
+	if err := NewDelimiterPacket(0, 16).Write(w, []byte("a\x00b")); err != ErrDelimiterInFrame {
+		t.Fatalf("expected delimiter error, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing written, got %q", buf.Bytes())
+	}
+	// 其他的分隔符策略不处理\r
+	if err := NewDelimiterPacket(0, 16).Write(w, []byte("a\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := NewDelimiterPacket(0, 16).Read(libio.NewReader(buf)); err != nil || string(val) != "a\r\n" {
+		t.Fatalf("expected a\\r\\n, got %q %v", val, err)
+	}
+}
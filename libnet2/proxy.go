@@ -0,0 +1,309 @@
+package libnet2
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+const (
+	// v1的包头最长107个字节
+	proxyV1MaxSize = 107
+	// v2的固定包头
+	proxyV2HeadSize = 16
+	// 没有设置 MaxConn 的时候，同时解析包头的连接数
+	proxyMaxHandshakes = 1024
+	// 解析完包头之后等待服务 Accept 的时间，达到 MaxConn 的时候等不到，关闭连接
+	proxyAcceptWait = 200 * time.Millisecond
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// PROXY protocol 的包头
+type ProxyHeader struct {
+	// 1或者2
+	Version int
+	// 客户端的地址，LOCAL和UNKNOWN的时候为nil
+	Source net.Addr
+	// 客户端连接的负载均衡的地址
+	Destination net.Addr
+}
+
+// 解析PROXY protocol的监听，每个连接在单独的协程中解析包头，慢的连接不会卡住监听
+// 包头不合法的连接关闭之后，Accept 返回 *RejectError，服务会通知 Handler.OnError 之后继续
+// 同时解析的连接数按照 MaxConn 限制，满了之后不再从系统取连接
+type proxyListener struct {
+	listener net.Listener
+	timeout  time.Duration
+	// 解析中的连接的名额
+	slots chan bool
+
+	connChan  chan net.Conn
+	errChan   chan error
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+func newProxyListener(listener net.Listener, timeout time.Duration, maxConn int32) *proxyListener {
+	l := new(proxyListener)
+	l.listener = listener
+	l.timeout = timeout
+	size := int(maxConn)
+	if size <= 0 {
+		size = proxyMaxHandshakes
+	}
+	l.slots = make(chan bool, size)
+	l.connChan = make(chan net.Conn)
+	l.errChan = make(chan error, 1)
+	l.closeChan = make(chan bool)
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyListener) acceptLoop() {
+	var delay time.Duration
+	for {
+		select {
+		case l.slots <- true:
+		case <-l.closeChan:
+			return
+		}
+		conn, err := l.listener.Accept()
+		if err != nil {
+			<-l.slots
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				if max := 1 * time.Second; delay > max {
+					delay = max
+				}
+				time.Sleep(delay)
+				continue
+			}
+			select {
+			case l.errChan <- err:
+			case <-l.closeChan:
+			}
+			return
+		}
+		delay = 0
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	defer func() {
+		<-l.slots
+	}()
+	if l.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+	}
+	header, err := readProxyHeader(conn)
+	if err != nil {
+		conn.Close()
+		select {
+		case l.errChan <- &RejectError{Addr: conn.RemoteAddr(), Err: err}:
+		case <-l.closeChan:
+		}
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	timer := time.NewTimer(proxyAcceptWait)
+	defer timer.Stop()
+	select {
+	case l.connChan <- newProxyConn(conn, header):
+	case <-timer.C:
+		// 服务的连接已经满了
+		conn.Close()
+		select {
+		case l.errChan <- &RejectError{Addr: conn.RemoteAddr(), Err: ErrConnLimit}:
+		case <-l.closeChan:
+		}
+	case <-l.closeChan:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connChan:
+		return conn, nil
+	case err := <-l.errChan:
+		return nil, err
+	case <-l.closeChan:
+		return nil, errClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+		err = l.listener.Close()
+	})
+	return err
+}
+
+func (l *proxyListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// 解析过包头的连接，RemoteAddr 返回客户端真实的地址
+type proxyConn struct {
+	net.Conn
+	header *ProxyHeader
+}
+
+func newProxyConn(conn net.Conn, header *ProxyHeader) *proxyConn {
+	c := new(proxyConn)
+	c.Conn = conn
+	c.header = header
+	return c
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// 包头之后的数据还在socket中，reactor模式可以直接使用fd
+func (c *proxyConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrReactorUnsupported
+	}
+	return sc.SyscallConn()
+}
+
+// session的PROXY protocol包头，没有开启的时候返回false
+func ProxyHeaderOf(sess Session2Interface) (*ProxyHeader, bool) {
+	conn := sess.GetConn()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return pc.header, true
+	}
+	return nil, false
+}
+
+// 读取包头，只读取包头的字节，后面的数据留在连接中
+func readProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	head := make([]byte, len(proxyV2Signature))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(head, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(head, proxyV1Prefix) {
+		return readProxyV1(r, head)
+	}
+	return nil, ErrProxyHeader
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r io.Reader, head []byte) (*ProxyHeader, error) {
+	line := append(make([]byte, 0, proxyV1MaxSize), head...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxSize {
+			return nil, ErrProxyHeader
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	header := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrProxyHeader
+	}
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	header.Source, header.Destination = src, dst
+	return header, nil
+}
+
+func parseProxyAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil || p < 0 || p > 65535 {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+// 二进制的包头，签名之后是版本和命令，地址族，地址的长度
+func readProxyV2(r io.Reader) (*ProxyHeader, error) {
+	head := make([]byte, proxyV2HeadSize-len(proxyV2Signature))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[0]>>4 != 2 {
+		return nil, ErrProxyHeader
+	}
+	body := make([]byte, libio.GetUint16BE(head[2:4]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	header := &ProxyHeader{Version: 2}
+	if head[0]&0x0F == 0 {
+		// LOCAL，负载均衡自己的健康检查
+		return header, nil
+	}
+	if head[0]&0x0F != 1 {
+		return nil, ErrProxyHeader
+	}
+	var size int
+	switch head[1] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// UNSPEC和unix地址，使用连接自己的地址
+		return header, nil
+	}
+	if len(body) < size*2+4 {
+		return nil, ErrProxyHeader
+	}
+	srcIP := net.IP(append([]byte(nil), body[:size]...))
+	dstIP := net.IP(append([]byte(nil), body[size:size*2]...))
+	srcPort := int(libio.GetUint16BE(body[size*2 : size*2+2]))
+	dstPort := int(libio.GetUint16BE(body[size*2+2 : size*2+4]))
+	if head[1]&0x0F == 2 {
+		header.Source = &net.UDPAddr{IP: srcIP, Port: srcPort}
+		header.Destination = &net.UDPAddr{IP: dstIP, Port: dstPort}
+	} else {
+		header.Source = &net.TCPAddr{IP: srcIP, Port: srcPort}
+		header.Destination = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	}
+	return header, nil
+}
@@ -0,0 +1,113 @@
+package libnet2
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := append([]byte(nil), proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12, 10, 0, 0, 1, 192, 168, 0, 1, 0x1F, 0x90, 0x01, 0xBB)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		version int
+		source  string
+	}{
+		{"v1", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 4000 443\r\nhello"), 1, "1.2.3.4:4000"},
+		{"v1 ipv6", []byte("PROXY TCP6 ::1 ::2 4000 443\r\nhello"), 1, "[::1]:4000"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\nhello"), 1, ""},
+		{"v2", append(v2, []byte("hello")...), 2, "10.0.0.1:8080"},
+	}
+	for _, c := range cases {
+		r := bytes.NewReader(c.data)
+		header, err := readProxyHeader(r)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		source := ""
+		if header.Source != nil {
+			source = header.Source.String()
+		}
+		if header.Version != c.version || source != c.source {
+			t.Fatalf("%s: unexpected header %d %s", c.name, header.Version, source)
+		}
+		// 包头之后的数据不能被读走
+		if rest, _ := io.ReadAll(r); string(rest) != "hello" {
+			t.Fatalf("%s: unexpected rest %q", c.name, rest)
+		}
+	}
+
+	for _, bad := range []string{"GET / HTTP/1.1\r\n\r\n", "PROXY TCP4 1.2.3.4 x 1 2\r\n", "PROXY TCP4 1.2.3.4 5.6.7.8 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6\r\n"} {
+		if _, err := readProxyHeader(bytes.NewReader([]byte(bad))); err != ErrProxyHeader {
+			t.Fatalf("%q: expected proxy header error, got %v", bad, err)
+		}
+	}
+}
+
+func TestProxyListenerLimit(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newProxyListener(inner, 300*time.Millisecond, 1)
+	defer l.Close()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	accept := func() (net.Conn, error) {
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			conn, err := l.Accept()
+			ch <- result{conn, err}
+		}()
+		select {
+		case r := <-ch:
+			return r.conn, r.err
+		case <-time.After(2 * time.Second):
+			t.Fatal("accept blocked")
+			return nil, nil
+		}
+	}
+
+	// 慢的包头占着唯一的名额，后面的连接等它超时之后才解析
+	slow := dial()
+	defer slow.Close()
+	time.Sleep(50 * time.Millisecond)
+	fast := dial()
+	defer fast.Close()
+	fast.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 4000 443\r\n"))
+	if _, err := accept(); err == nil {
+		t.Fatal("expected slow header rejected first")
+	}
+	conn, err := accept()
+	if err != nil || conn.RemoteAddr().String() != "1.2.3.4:4000" {
+		t.Fatalf("expected proxied conn, got %v", err)
+	}
+	conn.Close()
+
+	// 没有 Accept 来取的时候，等一会之后关闭连接
+	idle := dial()
+	defer idle.Close()
+	idle.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 4001 443\r\n"))
+	idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected closed conn, got %v", err)
+	}
+	_, err = accept()
+	if e, ok := err.(*RejectError); !ok || e.Err != ErrConnLimit {
+		t.Fatalf("expected conn limit, got %v", err)
+	}
+}
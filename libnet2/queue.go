@@ -0,0 +1,147 @@
+package libnet2
+
+import (
+	"time"
+
+	"github.com/wuqifei/server_lib/concurrent"
+)
+
+// session的队列
+type QueueType int
+
+const (
+	// 发送队列
+	QueueSend QueueType = iota
+	// 接收队列
+	QueueRecv
+)
+
+func (queue QueueType) String() string {
+	if queue == QueueSend {
+		return "send"
+	}
+	return "recv"
+}
+
+// 队列水位的回调，size为当时队列中的条数
+type OnSessWatermark func(sess Session2Interface, queue QueueType, size int)
+
+// 取队列对应的配置
+func (s *defaultSession) queue(queue QueueType) (chan []byte, OverflowPolicy, time.Duration, *concurrent.AtomicBoolean) {
+	if queue == QueueSend {
+		return s.sendChan, s.option.SendPolicy, s.option.SendTimeout, s.sendHigh
+	}
+	return s.recvChan, s.option.RecvPolicy, s.option.RecvTimeout, s.recvHigh
+}
+
+// 放入队列，block为false的时候，队列满了直接返回，不使用队列的策略
+func (s *defaultSession) push(queue QueueType, val []byte, block bool) error {
+	ch, policy, timeout, _ := s.queue(queue)
+	select {
+	case <-s.closeChan:
+		return ErrSessionClosed
+	default:
+	}
+	select {
+	case ch <- val:
+		s.pushed(queue)
+		return nil
+	default:
+	}
+	if !block {
+		return ErrQueueFull
+	}
+
+	switch policy {
+	case OverflowDropNewest:
+		return ErrQueueFull
+
+	case OverflowDropOldest:
+		// 只挤掉一次，多个协程同时放入的时候空出来的位置可能被别人占了，这次算作丢弃
+		select {
+		case old := <-ch:
+			s.dropped(queue, old)
+		default:
+		}
+		select {
+		case ch <- val:
+			s.pushed(queue)
+			return nil
+		default:
+			return ErrQueueFull
+		}
+
+	case OverflowDisconnect:
+		s.Close()
+		return ErrQueueFull
+	}
+
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+	select {
+	case ch <- val:
+		s.pushed(queue)
+		return nil
+	case <-s.closeChan:
+		return ErrSessionClosed
+	case <-timeoutChan:
+		return ErrQueueTimeout
+	}
+}
+
+// 丢弃队列中取出的数据，和正常取出一样更新水位
+// 接收队列中的包是从缓冲池中分配的，要放回去，发送队列中的包是使用者的，不能放回
+func (s *defaultSession) dropped(queue QueueType, val []byte) {
+	s.popped(queue)
+	if queue == QueueRecv {
+		s.release(val)
+	}
+}
+
+// 队列实际使用的水位，高水位不超过队列的容量，低水位小于高水位
+func (s *defaultSession) watermark(ch chan []byte) (int, int) {
+	high, low := s.option.HighWatermark, s.option.LowWatermark
+	if high > cap(ch) {
+		high = cap(ch)
+	}
+	if low >= high {
+		low = high - 1
+	}
+	return high, low
+}
+
+// 放入之后检查高水位，每次越过只触发一次
+func (s *defaultSession) pushed(queue QueueType) {
+	if s.option.HighWatermark <= 0 {
+		return
+	}
+	ch, _, _, high := s.queue(queue)
+	size := len(ch)
+	highWatermark, _ := s.watermark(ch)
+	if size < highWatermark || !high.CompareAndSet(false, true) {
+		return
+	}
+	if onHigh := s.handler.onHighWatermark(); onHigh != nil {
+		onHigh(s, queue, size)
+	}
+}
+
+// 取出之后检查低水位，只有达到过高水位才会触发
+func (s *defaultSession) popped(queue QueueType) {
+	if s.option.HighWatermark <= 0 {
+		return
+	}
+	ch, _, _, high := s.queue(queue)
+	size := len(ch)
+	_, lowWatermark := s.watermark(ch)
+	if size > lowWatermark || !high.Get() || !high.CompareAndSet(true, false) {
+		return
+	}
+	if onLow := s.handler.onLowWatermark(); onLow != nil {
+		onLow(s, queue, size)
+	}
+}
@@ -0,0 +1,197 @@
+package libnet2
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 水位回调触发的次数
+type queueCounter struct {
+	high, low int
+}
+
+// 不启动读写协程的session，只测试队列
+func newQueueSession(t *testing.T, option *SessionOption2) (*defaultSession, *queueCounter) {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	counter := new(queueCounter)
+	handler := NewHandler(NewLengthPacket(2, BigEndian, 1024))
+	handler.OnHighWatermark = func(sess Session2Interface, queue QueueType, size int) {
+		counter.high++
+	}
+	handler.OnLowWatermark = func(sess Session2Interface, queue QueueType, size int) {
+		counter.low++
+	}
+	return newDefaultSession(conn, option, handler), counter
+}
+
+func queueOption(policy OverflowPolicy) *SessionOption2 {
+	option := DefaultSessionOption()
+	option.SendChanSize = 2
+	option.SendPolicy = policy
+	return option
+}
+
+func TestQueueBlock(t *testing.T) {
+	option := queueOption(OverflowBlock)
+	option.SendTimeout = 20 * time.Millisecond
+	sess, _ := newQueueSession(t, option)
+	sess.Send([]byte("a"))
+	sess.Send([]byte("b"))
+	if err := sess.TrySend([]byte("c")); err != ErrQueueFull {
+		t.Fatalf("expected queue full, got %v", err)
+	}
+	start := time.Now()
+	if err := sess.Send([]byte("c")); err != ErrQueueTimeout {
+		t.Fatalf("expected queue timeout, got %v", err)
+	}
+	if cost := time.Since(start); cost < option.SendTimeout {
+		t.Fatalf("returned after %v", cost)
+	}
+	// 阻塞的时候关闭直接返回
+	option.SendTimeout = 0
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		sess.Close()
+	}()
+	if err := sess.Send([]byte("c")); err != ErrSessionClosed {
+		t.Fatalf("expected session closed, got %v", err)
+	}
+}
+
+func TestQueueDropNewest(t *testing.T) {
+	sess, _ := newQueueSession(t, queueOption(OverflowDropNewest))
+	sess.Send([]byte("a"))
+	sess.Send([]byte("b"))
+	if err := sess.Send([]byte("c")); err != ErrQueueFull {
+		t.Fatalf("expected queue full, got %v", err)
+	}
+	if a, b := <-sess.sendChan, <-sess.sendChan; string(a) != "a" || string(b) != "b" {
+		t.Fatalf("expected a b, got %s %s", a, b)
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	option := queueOption(OverflowDropOldest)
+	option.HighWatermark = 2
+	option.LowWatermark = 1
+	sess, counter := newQueueSession(t, option)
+	sess.Send([]byte("a"))
+	sess.Send([]byte("b"))
+	if err := sess.Send([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if b, c := <-sess.sendChan, <-sess.sendChan; string(b) != "b" || string(c) != "c" {
+		t.Fatalf("expected b c, got %s %s", b, c)
+	}
+	// 丢弃的时候回落到低水位，放入新的又达到高水位
+	if counter.high != 2 || counter.low != 1 {
+		t.Fatalf("expected 2 high 1 low, got %d %d", counter.high, counter.low)
+	}
+}
+
+func TestQueueDropOldestConcurrent(t *testing.T) {
+	sess, _ := newQueueSession(t, queueOption(OverflowDropOldest))
+	// 没有人取，多个协程同时往满的队列放，每次最多挤一次，不会一直空转
+	var wg sync.WaitGroup
+	results := make(chan error, 8*1000)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				results <- sess.Send([]byte("x"))
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+	for err := range results {
+		if err != nil && err != ErrQueueFull {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if len(sess.sendChan) != cap(sess.sendChan) {
+		t.Fatalf("expected full queue, got %d", len(sess.sendChan))
+	}
+}
+
+func TestQueueDisconnect(t *testing.T) {
+	sess, _ := newQueueSession(t, queueOption(OverflowDisconnect))
+	sess.Send([]byte("a"))
+	sess.Send([]byte("b"))
+	if err := sess.Send([]byte("c")); err != ErrQueueFull {
+		t.Fatalf("expected queue full, got %v", err)
+	}
+	select {
+	case <-sess.closeChan:
+	default:
+		t.Fatal("expected session closed")
+	}
+	if err := sess.Send([]byte("d")); err != ErrSessionClosed {
+		t.Fatalf("expected session closed, got %v", err)
+	}
+}
+
+func TestQueueWatermarkOption(t *testing.T) {
+	option := queueOption(OverflowPolicy(100))
+	option.HighWatermark = 10
+	option.LowWatermark = 20
+	sess, counter := newQueueSession(t, option)
+	if option.SendPolicy != OverflowBlock || option.LowWatermark != 9 {
+		t.Fatalf("unexpected option %v %d", option.SendPolicy, option.LowWatermark)
+	}
+	// 高水位超过容量的时候，队列满了就触发
+	sess.Send([]byte("a"))
+	sess.Send([]byte("b"))
+	if counter.high != 1 {
+		t.Fatalf("expected high watermark, got %d", counter.high)
+	}
+	<-sess.sendChan
+	sess.popped(QueueSend)
+	if counter.low != 1 {
+		t.Fatalf("expected low watermark, got %d", counter.low)
+	}
+}
+
+func TestHeartbeatQueueFull(t *testing.T) {
+	option := queueOption(OverflowBlock)
+	option.Heartbeat = NewBytesHeartbeat([]byte("ping"), []byte("pong"))
+	option.HeartbeatInterval = 10 * time.Millisecond
+	sess, _ := newQueueSession(t, option)
+	var states []IdleState
+	sess.handler.OnIdle = func(sess Session2Interface, state IdleState) {
+		states = append(states, state)
+	}
+	// 对端不读，处理协程没有启动，队列一直是满的
+	for i := 0; i < option.SendChanSize; i++ {
+		if err := sess.TrySend([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan bool)
+	go func() {
+		now := time.Now().Add(option.HeartbeatInterval)
+		sess.tick(now)
+		sess.tick(now.Add(option.HeartbeatInterval))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat blocked the session loop")
+	}
+	// 同一段写不出去只通知一次
+	if len(states) != 1 || states[0] != IdleWrite {
+		t.Fatalf("expected one write idle, got %v", states)
+	}
+	if len(sess.sendChan) != option.SendChanSize {
+		t.Fatalf("expected full send queue, got %d", len(sess.sendChan))
+	}
+}
@@ -0,0 +1,653 @@
+// +build linux
+
+package libnet2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wuqifei/server_lib/concurrent"
+	"github.com/wuqifei/server_lib/libio"
+)
+
+const (
+	// 每个循环一次读取的缓冲
+	reactorReadSize = 64 * 1024
+	// 一次epoll_wait最多的事件
+	reactorEvents = 256
+	// 没有事件的时候，最长多久检查一次超时和退出
+	reactorMaxWait = time.Second
+)
+
+// epoll的事件循环，固定数目的循环处理所有连接，每个连接不再需要单独的协程
+// 连接仍然由net包Accept，只是读写不再经过runtime，而是由循环直接操作fd
+type reactor struct {
+	loops []*reactorLoop
+	next  *concurrent.AtomicUint64
+}
+
+func newReactor(count int) (*reactor, error) {
+	r := new(reactor)
+	r.next = concurrent.NewAtomicUint64(0)
+	for i := 0; i < count; i++ {
+		loop, err := newReactorLoop()
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.loops = append(r.loops, loop)
+		go loop.run()
+	}
+	return r, nil
+}
+
+// 按顺序分配到循环上
+func (r *reactor) newSession(conn net.Conn, option *SessionOption2, handler *Handler) (serverSession, error) {
+	loop := r.loops[r.next.IncrementAndGet()%uint64(len(r.loops))]
+	return newReactorSession(loop, conn, option, handler)
+}
+
+// 停止所有的循环，循环上还没关闭的session一起关闭
+func (r *reactor) close() {
+	for _, loop := range r.loops {
+		loop.stop()
+	}
+}
+
+type reactorLoop struct {
+	epfd int
+
+	mutex    sync.Mutex
+	sessions map[int]*reactorSession
+
+	stopFlag *concurrent.AtomicBoolean
+}
+
+func newReactorLoop() (*reactorLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	l := new(reactorLoop)
+	l.epfd = epfd
+	l.sessions = make(map[int]*reactorSession)
+	l.stopFlag = concurrent.NewAtomicBoolean(false)
+	return l, nil
+}
+
+func (l *reactorLoop) add(sess *reactorSession) error {
+	l.mutex.Lock()
+	l.sessions[sess.fd] = sess
+	l.mutex.Unlock()
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(sess.fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, sess.fd, event); err != nil {
+		l.del(sess)
+		return err
+	}
+	return nil
+}
+
+func (l *reactorLoop) del(sess *reactorSession) {
+	l.mutex.Lock()
+	if l.sessions[sess.fd] == sess {
+		delete(l.sessions, sess.fd)
+	}
+	l.mutex.Unlock()
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, sess.fd, nil)
+}
+
+// 发送缓冲没有写完的时候，等待可写
+func (l *reactorLoop) watchWrite(sess *reactorSession, watch bool) {
+	events := uint32(syscall.EPOLLIN | syscall.EPOLLRDHUP)
+	if watch {
+		events |= syscall.EPOLLOUT
+	}
+	event := &syscall.EpollEvent{Events: events, Fd: int32(sess.fd)}
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, sess.fd, event)
+}
+
+func (l *reactorLoop) get(fd int) *reactorSession {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.sessions[fd]
+}
+
+// 循环退出的时候关闭剩下的session，不再有事件了
+func (l *reactorLoop) closeAll() {
+	l.mutex.Lock()
+	list := make([]*reactorSession, 0, len(l.sessions))
+	for _, sess := range l.sessions {
+		list = append(list, sess)
+	}
+	l.mutex.Unlock()
+	for _, sess := range list {
+		sess.Close()
+	}
+}
+
+func (l *reactorLoop) stop() {
+	l.stopFlag.Set(true)
+}
+
+func (l *reactorLoop) run() {
+	defer syscall.Close(l.epfd)
+	defer l.closeAll()
+	buf := make([]byte, reactorReadSize)
+	events := make([]syscall.EpollEvent, reactorEvents)
+	lastCheck := time.Now()
+	for !l.stopFlag.Get() {
+		n, err := syscall.EpollWait(l.epfd, events, int(reactorMaxWait/time.Millisecond))
+		if err != nil && err != syscall.EINTR {
+			return
+		}
+		for i := 0; i < n; i++ {
+			sess := l.get(int(events[i].Fd))
+			if sess == nil {
+				continue
+			}
+			if events[i].Events&syscall.EPOLLOUT != 0 {
+				sess.onWritable()
+			}
+			if events[i].Events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				sess.onReadable(buf)
+			}
+		}
+		if now := time.Now(); now.Sub(lastCheck) >= reactorMaxWait {
+			lastCheck = now
+			l.checkTimeout(now)
+		}
+	}
+}
+
+// 读取超时的检查，和普通的session一样按照次数计算
+func (l *reactorLoop) checkTimeout(now time.Time) {
+	l.mutex.Lock()
+	expired := make([]*reactorSession, 0)
+	for _, sess := range l.sessions {
+		times := int(now.Sub(time.Unix(0, sess.lastRead.Get())) / sess.option.ReadTimeout)
+		if times > sess.option.ReadTimeoutTimes {
+			expired = append(expired, sess)
+		}
+	}
+	l.mutex.Unlock()
+	for _, sess := range expired {
+		sess.Close()
+	}
+}
+
+// reactor模式的session
+// 收到的信息在循环中直接回调，OnRecv 中不能阻塞，否则同一个循环上的连接都会被卡住
+// Send 写入发送缓冲之后立即尝试写出，写不完的等待可写的时候继续，永远不会阻塞
+type reactorSession struct {
+	loop   *reactorLoop
+	conn   net.Conn
+	fd     int
+	option *SessionOption2
+	id     uint64
+	params *concurrent.ConcurrentMap
+
+	handler *Handler
+	onClose OnSessClose
+	onRecv  OnSessRecv
+
+	lastRead    *concurrent.AtomicInt64
+	recvLimiter *TokenBucket
+	metrics     *netMetrics
+
+	// 没有解析完的数据，只在循环中使用
+	in       []byte
+	inReader *bytes.Reader
+	reader   *libio.Reader
+	// 包头已经解析出来的时候，整个包的长度，收够之前不再解析
+	need int
+
+	// 发送缓冲，每个包一项，最多 SendChanSize 个
+	mutex sync.Mutex
+	out   [][]byte
+	// 第一个包已经写出了一部分
+	partial bool
+	// 没有实现 FrameEncoder 的包通过 writer 编码到这里
+	frame    []byte
+	writer   *libio.Writer
+	watching bool
+	closed   bool
+	// 优雅关闭，发送缓冲写完之后关闭
+	draining bool
+}
+
+func newReactorSession(loop *reactorLoop, conn net.Conn, option *SessionOption2, handler *Handler) (*reactorSession, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrReactorUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	sess := new(reactorSession)
+	if err = raw.Control(func(fd uintptr) { sess.fd = int(fd) }); err != nil {
+		return nil, err
+	}
+	sess.loop = loop
+	sess.conn = conn
+	sess.option = option
+	sess.handler = handler
+	if sess.option.ReadTimeout == 0 {
+		sess.option.ReadTimeout = time.Duration(60) * time.Second
+	}
+	if sess.option.SendChanSize < 1 {
+		sess.option.SendChanSize = 1
+	}
+	sess.id = globalSessionId.IncrementAndGet()
+	sess.params = concurrent.NewCocurrentMap()
+	sess.metrics = newNetMetrics(metrics)
+	sess.lastRead = concurrent.NewAtomicInt64(time.Now().UnixNano())
+	if option.RecvRate > 0 {
+		sess.recvLimiter = NewTokenBucket(option.RecvRate, option.RecvBurst)
+	}
+	sess.inReader = bytes.NewReader(nil)
+	sess.reader = libio.NewReader(sess.inReader)
+	if option.FramePool != nil {
+		sess.reader.SetPool(option.FramePool)
+	}
+	sess.writer = libio.NewWriter(&reactorWriter{sess: sess})
+	return sess, nil
+}
+
+// 注册到循环中，开始接收数据
+func (s *reactorSession) Accept() {
+	s.metrics.open(s)
+	if err := s.loop.add(s); err != nil {
+		if onError := s.handler.onSessError(); onError != nil {
+			onError(s, err)
+		}
+		s.Close()
+	}
+}
+
+// 在循环中读取并解析
+func (s *reactorSession) onReadable(buf []byte) {
+	// 关闭之后fd可能已经被新的连接复用，读写都要在锁里确认没有关闭
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	n, err := syscall.Read(s.fd, buf)
+	s.mutex.Unlock()
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return
+	}
+	if n > 0 {
+		s.lastRead.Set(time.Now().UnixNano())
+		s.in = append(s.in, buf[:n]...)
+		if !s.parse() {
+			s.Close()
+			return
+		}
+	}
+	if n == 0 || err != nil {
+		// 对端关闭，已经收到的都处理过了
+		s.Close()
+	}
+}
+
+// 解析出所有完整的包，返回false的时候关闭
+// 包实现了 FrameSizer 的时候，包没收完之前不会再去解析，大包分多次到达也不会反复分配
+func (s *reactorSession) parse() bool {
+	packet := s.handler.packet()
+	sizer, _ := packet.(FrameSizer)
+	offset := 0
+	defer func() {
+		// 剩下不完整的包移到开头
+		if offset > 0 {
+			s.in = s.in[:copy(s.in, s.in[offset:])]
+		}
+	}()
+	for offset < len(s.in) {
+		if sizer != nil {
+			if s.need == 0 {
+				need, err := sizer.FrameSize(s.in[offset:])
+				if err != nil {
+					// 后面的数据已经没法解析了
+					if onError := s.handler.onSessError(); onError != nil {
+						onError(s, err)
+					}
+					return false
+				}
+				if need == 0 {
+					// 包头还没收完
+					return true
+				}
+				s.need = need
+			}
+			if len(s.in)-offset < s.need {
+				return true
+			}
+			s.need = 0
+		}
+		s.inReader.Reset(s.in[offset:])
+		s.reader.Reset(s.inReader)
+		data, err := packet.Read(s.reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// 包还没收完
+			return true
+		}
+		consumed := len(s.in) - offset - s.inReader.Len()
+		offset += consumed
+		if err != nil {
+			if onError := s.handler.onSessError(); onError != nil {
+				onError(s, err)
+			}
+			if isFatalError(err) || consumed == 0 {
+				return false
+			}
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		s.metrics.recv(len(data))
+		if s.recvLimiter != nil && !s.recvLimiter.Allow() {
+			if onError := s.handler.onSessError(); onError != nil {
+				onError(s, ErrRecvRateLimit)
+			}
+			return false
+		}
+		s.recv(data)
+		if s.isClosed() {
+			return true
+		}
+	}
+	return true
+}
+
+func (s *reactorSession) recv(val []byte) {
+	start := time.Now()
+	defer func() {
+		s.metrics.handle(time.Since(start))
+		if s.option.FramePool != nil {
+			s.option.FramePool.Put(val)
+		}
+	}()
+	onRecv := s.onRecv
+	if onRecv == nil {
+		onRecv = s.handler.onRecv()
+	}
+	if onRecv != nil {
+		onRecv(s, val)
+	}
+}
+
+// 在循环中继续写出发送缓冲
+func (s *reactorSession) onWritable() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	err := s.flush()
+	done := s.draining && len(s.out) == 0
+	s.mutex.Unlock()
+	if err != nil || done {
+		s.Close()
+	}
+}
+
+// 尽量写出发送缓冲，写不完的时候等待可写，需要持有锁
+func (s *reactorSession) flush() error {
+	for len(s.out) > 0 {
+		n, err := syscall.Write(s.fd, s.out[0])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			if !s.watching {
+				s.watching = true
+				s.loop.watchWrite(s, true)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n < len(s.out[0]) {
+			s.out[0] = s.out[0][n:]
+			s.partial = true
+			continue
+		}
+		s.partial = false
+		s.remove(0)
+	}
+	if s.watching {
+		s.watching = false
+		s.loop.watchWrite(s, false)
+	}
+	return nil
+}
+
+// 从发送缓冲中去掉一个包，需要持有锁
+func (s *reactorSession) remove(i int) {
+	last := len(s.out) - 1
+	copy(s.out[i:], s.out[i+1:])
+	s.out[last] = nil
+	s.out = s.out[:last]
+}
+
+// 发送缓冲中的包达到 SendChanSize 的时候按照 SendPolicy 处理，需要持有锁
+// reactor模式的发送不能阻塞，OverflowBlock 和 OverflowDropNewest 一样返回 ErrQueueFull
+func (s *reactorSession) overflow() error {
+	if len(s.out) < s.option.SendChanSize {
+		return nil
+	}
+	if s.option.SendPolicy != OverflowDropOldest {
+		return ErrQueueFull
+	}
+	// 已经写出一部分的包不能丢，否则对端解析会错位
+	i := 0
+	if s.partial {
+		i = 1
+	}
+	if i < len(s.out) {
+		s.remove(i)
+	}
+	return nil
+}
+
+// 编码之后放进发送缓冲，需要持有锁
+func (s *reactorSession) encode(val []byte) error {
+	packet := s.handler.packet()
+	if encoder, ok := packet.(FrameEncoder); ok {
+		frame := make([]byte, encoder.FrameLen(len(val)))
+		n, err := encoder.EncodeFrame(frame, val)
+		if err != nil {
+			return err
+		}
+		s.out = append(s.out, frame[:n])
+		return nil
+	}
+	err := packet.Write(s.writer, val)
+	if err == nil && len(s.frame) > 0 {
+		s.out = append(s.out, s.frame)
+	}
+	s.frame = nil
+	return err
+}
+
+func (s *reactorSession) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+// 发送数据，写入发送缓冲，不会阻塞
+// 发送缓冲满的时候按照 SendPolicy 处理，见 overflow
+func (s *reactorSession) Send(val []byte) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrSessionClosed
+	}
+	err := s.overflow()
+	if err == nil {
+		err = s.encode(val)
+	}
+	if err == nil {
+		s.metrics.send(len(val))
+		err = s.flush()
+	}
+	s.mutex.Unlock()
+	switch err.(type) {
+	case nil, *FrameSizeError:
+	default:
+		if err != ErrQueueFull || s.option.SendPolicy == OverflowDisconnect {
+			// 连接已经不可用，或者按照策略断开
+			s.Close()
+		}
+	}
+	return err
+}
+
+// reactor模式的发送都先放到缓冲中，由循环写出
+func (s *reactorSession) queued() bool {
+	return true
+}
+
+// 和 Send 一样，reactor模式的发送不会阻塞
+func (s *reactorSession) TrySend(val []byte) error {
+	return s.Send(val)
+}
+
+// reactor模式不支持rpc
+func (s *reactorSession) Call(ctx context.Context, req []byte) ([]byte, error) {
+	return nil, ErrReactorUnsupported
+}
+
+// 关闭，可以重复调用
+func (s *reactorSession) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.out = nil
+	s.mutex.Unlock()
+
+	s.loop.del(s)
+	err := s.conn.Close()
+	s.metrics.close(s)
+	if s.onClose != nil {
+		s.onClose(s)
+	}
+	if onClose := s.handler.onClose(); onClose != nil {
+		onClose(s)
+	}
+	return err
+}
+
+// 优雅关闭，发送缓冲写完之后关闭
+func (s *reactorSession) shutdown() {
+	s.mutex.Lock()
+	s.draining = true
+	done := len(s.out) == 0
+	s.mutex.Unlock()
+	if done {
+		s.Close()
+	}
+}
+
+func (s *reactorSession) forceClose() {
+	s.Close()
+}
+
+func (s *reactorSession) stats() *netMetrics {
+	return s.metrics
+}
+
+func (s *reactorSession) setCloseHook(onClose OnSessClose) {
+	s.onClose = onClose
+}
+
+func (s *reactorSession) Recv(onRecv OnSessRecv) {
+	s.onRecv = onRecv
+}
+
+func (s *reactorSession) Set(key, val interface{}) error {
+	if key == nil || val == nil {
+		return ErrValueNull
+	}
+	s.params.Set(key, val)
+	return nil
+}
+
+func (s *reactorSession) Get(key interface{}) (interface{}, error) {
+	if key == nil {
+		return nil, ErrValueNull
+	}
+	return s.params.Get(key), nil
+}
+
+func (s *reactorSession) Del(key interface{}) (bool, error) {
+	if key == nil {
+		return false, ErrValueNull
+	}
+	s.params.Del(key)
+	return true, nil
+}
+
+func (s *reactorSession) Clear() error {
+	s.params.Dispose()
+	s.params = concurrent.NewCocurrentMap()
+	return nil
+}
+
+// 连接只用来获取地址和关闭，不要直接读写
+func (s *reactorSession) GetConn() net.Conn {
+	return s.conn
+}
+
+func (s *reactorSession) GetUniqueID() uint64 {
+	return s.id
+}
+
+// reactor模式没有阻塞的读取，返回nil
+func (s *reactorSession) Reader() *libio.Reader {
+	return nil
+}
+
+// 写入的内容原样放进发送缓冲
+func (s *reactorSession) Writer() *libio.Writer {
+	return libio.NewWriter(&reactorRawWriter{sess: s})
+}
+
+// 包的编码写入发送缓冲，调用的时候已经持有锁
+type reactorWriter struct {
+	sess *reactorSession
+}
+
+func (w *reactorWriter) Write(b []byte) (int, error) {
+	w.sess.frame = append(w.sess.frame, b...)
+	return len(b), nil
+}
+
+// 不经过包编码的写入
+type reactorRawWriter struct {
+	sess *reactorSession
+}
+
+func (w *reactorRawWriter) Write(b []byte) (int, error) {
+	s := w.sess
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, ErrSessionClosed
+	}
+	s.out = append(s.out, append([]byte(nil), b...))
+	return len(b), s.flush()
+}
@@ -0,0 +1,198 @@
+// +build linux
+
+package libnet2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/concurrent"
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 记录解析次数的包，包头的解析来自 LengthPacket
+type countedPacket struct {
+	*LengthPacket
+	reads *concurrent.AtomicInt32
+}
+
+func (p countedPacket) Read(r *libio.Reader) ([]byte, error) {
+	p.reads.IncrementAndGet()
+	return p.LengthPacket.Read(r)
+}
+
+// reactor模式的回显服务，返回服务以及关闭的session
+func newReactorServer(t *testing.T, packet PacketInterface, sessionOption *SessionOption2, onRecv OnSessRecv) (LibserverInterface, chan Session2Interface) {
+	option := DefaultOption()
+	option.Address = "127.0.0.1:0"
+	option.ReactorLoops = 2
+	handler := NewHandler(packet)
+	handler.OnRecv = onRecv
+	if onRecv == nil {
+		handler.OnRecv = func(sess Session2Interface, val []byte) {
+			sess.Send(append([]byte(nil), val...))
+		}
+	}
+	closeChan := make(chan Session2Interface, 16)
+	handler.OnClose = func(sess Session2Interface) {
+		closeChan <- sess
+	}
+	server, err := NewWithOption(option, sessionOption, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Run()
+	return server, closeChan
+}
+
+func dialReactor(t *testing.T, server LibserverInterface) (net.Conn, *libio.Reader, *libio.Writer) {
+	conn, err := net.Dial("tcp", server.Listener().Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, libio.NewReader(conn), libio.NewWriter(conn)
+}
+
+func waitSessionClose(t *testing.T, closeChan chan Session2Interface, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-closeChan:
+	case <-time.After(timeout):
+		t.Fatal("session not closed")
+	}
+}
+
+func TestReactorEcho(t *testing.T) {
+	packet := NewLengthPacket(4, BigEndian, 1<<20)
+	reads := concurrent.NewAtomicInt32(0)
+	server, closeChan := newReactorServer(t, countedPacket{packet, reads}, DefaultSessionOption(), nil)
+	defer server.Close()
+	conn, r, w := dialReactor(t, server)
+	defer conn.Close()
+
+	if err := packet.Write(w, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := packet.Read(r); err != nil || string(val) != "hello" {
+		t.Fatalf("expected hello, got %q %v", val, err)
+	}
+
+	// 大包分成很多小段慢慢到达
+	msg := bytes.Repeat([]byte("0123456789"), 20000)
+	frame := make([]byte, packet.FrameLen(len(msg)))
+	n, _ := packet.EncodeFrame(frame, msg)
+	for offset := 0; offset < n; offset += 4096 {
+		end := offset + 4096
+		if end > n {
+			end = n
+		}
+		if _, err := conn.Write(frame[offset:end]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if val, err := packet.Read(r); err != nil || !bytes.Equal(val, msg) {
+		t.Fatalf("expected large echo, got %d %v", len(val), err)
+	}
+	if stats := server.Stats(); stats.FramesIn != 2 {
+		t.Fatalf("expected 2 frames, got %d", stats.FramesIn)
+	}
+	// 包收完之前不会反复解析
+	if n := reads.Get(); n != 2 {
+		t.Fatalf("expected 2 reads, got %d", n)
+	}
+
+	// 对端关闭
+	conn.Close()
+	waitSessionClose(t, closeChan, time.Second)
+}
+
+func TestReactorReadTimeout(t *testing.T) {
+	option := DefaultSessionOption()
+	option.ReadTimeout = 50 * time.Millisecond
+	option.ReadTimeoutTimes = 1
+	server, closeChan := newReactorServer(t, NewLengthPacket(4, BigEndian, 1<<20), option, nil)
+	defer server.Close()
+	conn, _, _ := dialReactor(t, server)
+	defer conn.Close()
+
+	// 超时在循环中每秒检查一次
+	waitSessionClose(t, closeChan, 3*time.Second)
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestReactorShutdown(t *testing.T) {
+	server, closeChan := newReactorServer(t, NewLengthPacket(4, BigEndian, 1<<20), DefaultSessionOption(), nil)
+	conn, r, w := dialReactor(t, server)
+	defer conn.Close()
+	packet := NewLengthPacket(4, BigEndian, 1<<20)
+	packet.Write(w, []byte("hello"))
+	if val, err := packet.Read(r); err != nil || string(val) != "hello" {
+		t.Fatalf("expected hello, got %q %v", val, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitSessionClose(t, closeChan, time.Second)
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestReactorClose(t *testing.T) {
+	server, closeChan := newReactorServer(t, NewLengthPacket(4, BigEndian, 1<<20), DefaultSessionOption(), nil)
+	conn, r, w := dialReactor(t, server)
+	defer conn.Close()
+	packet := NewLengthPacket(4, BigEndian, 1<<20)
+	packet.Write(w, []byte("hello"))
+	if _, err := packet.Read(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// 循环停止的时候关闭上面的session
+	server.Close()
+	waitSessionClose(t, closeChan, 3*time.Second)
+}
+
+func TestReactorSendOverflow(t *testing.T) {
+	option := DefaultSessionOption()
+	option.SendChanSize = 4
+	option.SendPolicy = OverflowDropNewest
+	result := make(chan int, 1)
+	big := make([]byte, 64*1024)
+	server, _ := newReactorServer(t, NewLengthPacket(4, BigEndian, 1<<20), option, func(sess Session2Interface, val []byte) {
+		// 对端不读，内核的缓冲满了之后发送缓冲最多留4个包
+		failed := 0
+		for i := 0; i < 1000; i++ {
+			if err := sess.Send(big); err == ErrQueueFull {
+				failed++
+			} else if err != nil {
+				break
+			}
+		}
+		result <- failed
+	})
+	defer server.Close()
+	conn, _, w := dialReactor(t, server)
+	defer conn.Close()
+	NewLengthPacket(4, BigEndian, 1<<20).Write(w, []byte("go"))
+
+	select {
+	case failed := <-result:
+		if failed == 0 {
+			t.Fatal("expected send buffer full")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked")
+	}
+}
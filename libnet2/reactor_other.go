@@ -0,0 +1,20 @@
+// +build !linux
+
+package libnet2
+
+import "net"
+
+// 只有linux支持reactor模式
+type reactor struct {
+}
+
+func newReactor(count int) (*reactor, error) {
+	return nil, ErrReactorUnsupported
+}
+
+func (r *reactor) newSession(conn net.Conn, option *SessionOption2, handler *Handler) (serverSession, error) {
+	return nil, ErrReactorUnsupported
+}
+
+func (r *reactor) close() {
+}
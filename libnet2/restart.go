@@ -0,0 +1,268 @@
+package libnet2
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wuqifei/server_lib/signal"
+)
+
+// 热重启的时候，父进程通过这个环境变量告诉新的进程继承了哪些监听
+// 内容是逗号分隔的 network|address，按顺序对应从3开始的fd
+const inheritEnv = "LIBNET2_INHERIT"
+
+// 新的进程准备好之后往这个环境变量里的fd写一个字节，父进程收到之后才开始关闭自己的服务
+const readyEnv = "LIBNET2_READY"
+
+// 可以交给新的进程的监听，*net.TCPListener 和 *net.UDPConn
+type inheritSocket struct {
+	key  string
+	file interface {
+		File() (*os.File, error)
+	}
+	// 是父进程交过来的
+	inherited bool
+}
+
+var (
+	inheritOnce  sync.Once
+	inheritMutex sync.Mutex
+	// 父进程交过来还没有使用的监听
+	inherited map[string]*os.File
+	// 父进程交过来的监听，还有多少个没有开始服务
+	inheritPending int
+	// 通知父进程准备好的管道，不是热重启启动的时候为nil
+	readyFile *os.File
+	readyOnce sync.Once
+)
+
+func inheritKey(network, address string) string {
+	return network + "|" + address
+}
+
+// 新的进程的环境变量，去掉当前进程继承来的，加上交给新的进程的监听和准备好的fd
+func inheritEnviron(environ []string, keys []string, readyFd int) []string {
+	env := make([]string, 0, len(environ)+2)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, inheritEnv+"=") && !strings.HasPrefix(kv, readyEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return append(env, inheritEnv+"="+strings.Join(keys, ","), readyEnv+"="+strconv.Itoa(readyFd))
+}
+
+// 读取父进程交过来的监听和管道，只执行一次
+func loadInherited() {
+	inheritOnce.Do(func() {
+		inherited = make(map[string]*os.File)
+		// 再启动的子进程不应该看到
+		if val := os.Getenv(readyEnv); val != "" {
+			os.Unsetenv(readyEnv)
+			if fd, err := strconv.Atoi(val); err == nil {
+				readyFile = os.NewFile(uintptr(fd), "ready")
+			}
+		}
+		val := os.Getenv(inheritEnv)
+		if val == "" {
+			return
+		}
+		os.Unsetenv(inheritEnv)
+		for i, key := range strings.Split(val, ",") {
+			inherited[key] = os.NewFile(uintptr(3+i), key)
+		}
+		inheritPending = len(inherited)
+	})
+}
+
+// 取出父进程交过来的监听，每个只能取一次
+func inheritedFile(network, address string) *os.File {
+	loadInherited()
+	inheritMutex.Lock()
+	defer inheritMutex.Unlock()
+	key := inheritKey(network, address)
+	f := inherited[key]
+	delete(inherited, key)
+	return f
+}
+
+// 使用交过来的监听的服务开始运行，全部都在运行之后通知父进程
+func inheritedRunning() {
+	inheritMutex.Lock()
+	inheritPending--
+	done := inheritPending == 0
+	inheritMutex.Unlock()
+	if done {
+		Ready()
+	}
+}
+
+// 通知启动当前进程的父进程已经准备好了，父进程收到之后才开始关闭自己的服务
+// 交过来的监听全部用来启动服务并且 Run 之后会自动调用，没有用上全部监听的进程需要自己调用
+// 不是热重启启动的进程什么也不做，可以重复调用
+func Ready() error {
+	loadInherited()
+	var err error
+	readyOnce.Do(func() {
+		if readyFile == nil {
+			return
+		}
+		_, err = readyFile.Write([]byte{1})
+		readyFile.Close()
+	})
+	return err
+}
+
+// 流式的监听，有继承的socket的时候直接使用
+func listenStream(network, address string) (net.Listener, *inheritSocket, error) {
+	var listener net.Listener
+	var err error
+	f := inheritedFile(network, address)
+	if f != nil {
+		listener, err = net.FileListener(f)
+		f.Close()
+	} else {
+		listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	socket := &inheritSocket{key: inheritKey(network, address), inherited: f != nil}
+	if tl, ok := listener.(*net.TCPListener); ok {
+		socket.file = tl
+	}
+	return listener, socket, nil
+}
+
+// 按包收发的监听，有继承的socket的时候直接使用
+func listenPacket(network, address string) (net.PacketConn, *inheritSocket, error) {
+	var pc net.PacketConn
+	var err error
+	f := inheritedFile(network, address)
+	if f != nil {
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		pc, err = net.ListenPacket(network, address)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	socket := &inheritSocket{key: inheritKey(network, address), inherited: f != nil}
+	if uc, ok := pc.(*net.UDPConn); ok {
+		socket.file = uc
+	}
+	return pc, socket, nil
+}
+
+// 新的进程的命令行，测试中替换
+var childCommand = defaultChildCommand
+
+// 和当前进程一样的命令行
+func defaultChildCommand() (string, []string, error) {
+	path, err := os.Executable()
+	return path, os.Args, err
+}
+
+// 用同样的参数启动一个新的进程，把服务的监听交给它，等待新的进程准备好之后返回
+// 新的进程用同样的 Network 和 Address 新建服务的时候，会直接使用交过去的监听，不会有端口冲突
+// 新的进程在ctx到期之前没有调用 Ready 或者退出了，会被杀掉，返回 ErrRestartNotReady
+// 当前进程的服务还在运行，需要调用者关闭
+func StartChild(ctx context.Context, servers ...LibserverInterface) (*os.Process, error) {
+	keys := make([]string, 0, len(servers))
+	files := make([]*os.File, 0, len(servers)+1)
+	defer func() {
+		// 子进程有自己的一份，当前进程的副本可以关闭
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, server := range servers {
+		s, ok := server.(*defaultLibServer)
+		if !ok || s.socket == nil || s.socket.file == nil {
+			return nil, ErrRestartUnsupported
+		}
+		f, err := s.socket.file.File()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, s.socket.key)
+		files = append(files, f)
+	}
+
+	path, args, err := childCommand()
+	if err != nil {
+		return nil, err
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+	// 管道的写端跟在监听后面
+	readyFd := 3 + len(files)
+	files = append(files, readyWriter)
+
+	attr := new(os.ProcAttr)
+	attr.Env = inheritEnviron(os.Environ(), keys, readyFd)
+	attr.Files = append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...)
+	proc, err := os.StartProcess(path, args, attr)
+	for _, f := range files[:len(files)-1] {
+		setNonblock(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 只有子进程持有写端，子进程退出的时候读到EOF
+	readyWriter.Close()
+
+	readyChan := make(chan bool, 1)
+	go func() {
+		n, _ := ready.Read(make([]byte, 1))
+		readyChan <- n > 0
+	}()
+	select {
+	case ok := <-readyChan:
+		if ok {
+			return proc, nil
+		}
+	case <-ctx.Done():
+	}
+	proc.Kill()
+	proc.Wait()
+	return nil, ErrRestartNotReady
+}
+
+// 热重启，启动新的进程并等待它准备好之后，优雅关闭当前进程的服务
+// 新的进程没有准备好的时候，当前的服务继续运行，返回 ErrRestartNotReady
+// 优雅关闭超时返回ctx的错误，这个时候新的进程已经在运行了
+func Restart(ctx context.Context, servers ...LibserverInterface) error {
+	if _, err := StartChild(ctx, servers...); err != nil {
+		return err
+	}
+	var err error
+	for _, server := range servers {
+		if e := server.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// 收到 SIGUSR2 的时候热重启，需要配合 signal.InitSignal 使用
+// timeout 包括等待新的进程准备好以及旧的session优雅关闭的时间，到期之后强制关闭
+func RestartOnSignal(timeout time.Duration, servers ...LibserverInterface) {
+	signal.OnRestart(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		// 强制关闭剩下的session也算完成，新的进程已经在服务了
+		if err := Restart(ctx, servers...); err != nil && err != context.DeadlineExceeded {
+			return err
+		}
+		return nil
+	})
+}
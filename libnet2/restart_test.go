@@ -0,0 +1,141 @@
+// +build !windows
+
+package libnet2
+
+import (
+	"context"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 热重启测试中启动的子进程运行这个测试，使用父进程交过来的监听
+func TestRestartChild(t *testing.T) {
+	if os.Getenv(inheritEnv) == "" {
+		t.Skip("only runs in the restarted process")
+	}
+	quit := make(chan bool)
+	handler := NewHandler(NewLengthPacket(2, BigEndian, 1024))
+	handler.OnRecv = func(sess Session2Interface, val []byte) {
+		if string(val) == "quit" {
+			close(quit)
+			return
+		}
+		sess.Send(append([]byte("child:"), val...))
+	}
+	option := DefaultOption()
+	option.Address = "127.0.0.1:0"
+	server, err := NewWithOption(option, DefaultSessionOption(), handler)
+	if err != nil {
+		os.Exit(1)
+	}
+	// 交过来的监听全部在运行，自动通知父进程
+	server.Run()
+	select {
+	case <-quit:
+	case <-time.After(5 * time.Second):
+	}
+	// 直接退出，不输出测试的结果
+	os.Exit(0)
+}
+
+func TestInheritEnviron(t *testing.T) {
+	environ := []string{"PATH=/bin", inheritEnv + "=tcp|old", readyEnv + "=9", "HOME=/root"}
+	env := inheritEnviron(environ, []string{"tcp|:80", "udp|:53"}, 5)
+	expect := []string{"PATH=/bin", "HOME=/root", inheritEnv + "=tcp|:80,udp|:53", readyEnv + "=5"}
+	if !reflect.DeepEqual(env, expect) {
+		t.Fatalf("expected %v, got %v", expect, env)
+	}
+}
+
+func TestRestartHandover(t *testing.T) {
+	childCommand = func() (string, []string, error) {
+		path, err := os.Executable()
+		return path, []string{path, "-test.run=^TestRestartChild$"}, err
+	}
+	defer func() {
+		childCommand = defaultChildCommand
+	}()
+
+	packet := NewLengthPacket(2, BigEndian, 1024)
+	handler := NewHandler(packet)
+	handler.OnRecv = func(sess Session2Interface, val []byte) {
+		sess.Send(append([]byte("parent:"), val...))
+	}
+	option := DefaultOption()
+	option.Address = "127.0.0.1:0"
+	server, err := NewWithOption(option, DefaultSessionOption(), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Run()
+	address := server.Listener().Addr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	proc, err := StartChild(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Kill()
+	if err = server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个端口由子进程继续服务
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	w, r := libio.NewWriter(conn), libio.NewReader(conn)
+	packet.Write(w, []byte("hi"))
+	if val, err := packet.Read(r); err != nil || string(val) != "child:hi" {
+		t.Fatalf("expected child:hi, got %q %v", val, err)
+	}
+	packet.Write(w, []byte("quit"))
+	state, err := proc.Wait()
+	if err != nil || !state.Success() {
+		t.Fatalf("child exited with %v %v", state, err)
+	}
+}
+
+func TestStartChildNotReady(t *testing.T) {
+	defer func() {
+		childCommand = defaultChildCommand
+	}()
+	option := DefaultOption()
+	option.Address = "127.0.0.1:0"
+	server, err := NewWithOption(option, DefaultSessionOption(), NewHandler(NewLengthPacket(2, BigEndian, 1024)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// 没有通知就退出了
+	childCommand = func() (string, []string, error) {
+		return "/bin/sh", []string{"sh", "-c", "exit 0"}, nil
+	}
+	if _, err = StartChild(context.Background(), server); err != ErrRestartNotReady {
+		t.Fatalf("expected not ready, got %v", err)
+	}
+
+	// 一直没有通知，到期之后被杀掉
+	childCommand = func() (string, []string, error) {
+		return "/bin/sh", []string{"sh", "-c", "sleep 5"}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = StartChild(ctx, server); err != ErrRestartNotReady {
+		t.Fatalf("expected not ready, got %v", err)
+	}
+	if cost := time.Since(start); cost > 2*time.Second {
+		t.Fatalf("returned after %v", cost)
+	}
+}
@@ -0,0 +1,25 @@
+// +build !windows
+
+package libnet2
+
+import (
+	"os"
+	"syscall"
+)
+
+// os.StartProcess 会把交给子进程的fd改成阻塞的，复制出来的fd和监听共用状态
+// 不改回来的话，当前进程的 Accept 会阻塞在系统调用中，关闭监听的时候一直等待
+func setNonblock(f *os.File) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var e error
+	err = raw.Control(func(fd uintptr) {
+		e = syscall.SetNonblock(int(fd), true)
+	})
+	if err != nil {
+		return err
+	}
+	return e
+}
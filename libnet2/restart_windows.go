@@ -0,0 +1,10 @@
+// +build windows
+
+package libnet2
+
+import "os"
+
+// windows下不会改变监听的状态
+func setNonblock(f *os.File) error {
+	return nil
+}
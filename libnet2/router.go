@@ -0,0 +1,159 @@
+package libnet2
+
+import (
+	"sync"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// 路由的处理函数，中间件也是同样的类型
+type RouteFunc func(ctx *Context)
+
+// 命令号的解析策略
+// PacketInterface 负责拆包，RouteCodec 负责从一个完整的包中取出命令号
+type RouteCodec interface {
+	Decode(val []byte) (cmd uint32, body []byte, err error)
+	Encode(cmd uint32, body []byte) ([]byte, error)
+}
+
+// 默认的命令号解析，包头4个字节大端的命令号，后面是包体
+type defaultRouteCodec struct {
+}
+
+func (c *defaultRouteCodec) Decode(val []byte) (uint32, []byte, error) {
+	if len(val) < 4 {
+		return 0, nil, ErrRouteCmdShort
+	}
+	return libio.GetUint32BE(val[:4]), val[4:], nil
+}
+
+func (c *defaultRouteCodec) Encode(cmd uint32, body []byte) ([]byte, error) {
+	val := make([]byte, 4+len(body))
+	libio.PutUint32BE(val[:4], cmd)
+	copy(val[4:], body)
+	return val, nil
+}
+
+// 路由，类似http框架的mux
+// 把Router.OnRecv 设置到 Handler.OnRecv 上就可以使用
+type Router struct {
+	mutex sync.RWMutex
+
+	codec       RouteCodec
+	serializer  Serializer
+	routes      map[uint32][]RouteFunc
+	middlewares []RouteFunc
+	notFound    RouteFunc
+	onError     OnSessError
+}
+
+// 新建路由，codec为nil的时候使用默认的4字节大端命令号
+func NewRouter(codec RouteCodec) *Router {
+	r := new(Router)
+	if codec == nil {
+		codec = new(defaultRouteCodec)
+	}
+	r.codec = codec
+	r.serializer = NewJSONSerializer()
+	r.routes = make(map[uint32][]RouteFunc)
+	return r
+}
+
+// 命令号的解析对象
+func (r *Router) Codec() RouteCodec {
+	return r.codec
+}
+
+// 设置包体的序列化策略，默认为json
+func (r *Router) SetSerializer(serializer Serializer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.serializer = serializer
+}
+
+// 包体的序列化策略
+func (r *Router) Serializer() Serializer {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.serializer
+}
+
+// 添加全局的中间件，按照添加的顺序执行
+func (r *Router) Use(middlewares ...RouteFunc) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// 注册命令号的处理，handlers前面的可以作为这个路由单独的中间件
+func (r *Router) Handle(cmd uint32, handlers ...RouteFunc) {
+	if len(handlers) == 0 {
+		panic(ErrRouteHandlerNull)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.routes[cmd] = handlers
+}
+
+// 删除命令号的处理
+func (r *Router) Remove(cmd uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.routes, cmd)
+}
+
+// 没有找到路由时候的处理，同样会经过全局中间件
+func (r *Router) NotFound(handler RouteFunc) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.notFound = handler
+}
+
+// 解析命令号出错的回调
+func (r *Router) OnError(onError OnSessError) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.onError = onError
+}
+
+// 收到信息，签名和 OnSessRecv 一致
+func (r *Router) OnRecv(sess Session2Interface, val []byte) {
+	cmd, body, err := r.codec.Decode(val)
+	if err != nil {
+		r.error(sess, err)
+		return
+	}
+	r.Dispatch(sess, cmd, body)
+}
+
+func (r *Router) error(sess Session2Interface, err error) {
+	r.mutex.RLock()
+	onError := r.onError
+	r.mutex.RUnlock()
+	if onError != nil {
+		onError(sess, err)
+	}
+}
+
+// 直接分发一个已经解出命令号的包
+func (r *Router) Dispatch(sess Session2Interface, cmd uint32, body []byte) {
+	r.mutex.RLock()
+	routes, ok := r.routes[cmd]
+	if !ok && r.notFound != nil {
+		routes = []RouteFunc{r.notFound}
+		ok = true
+	}
+	var handlers []RouteFunc
+	if ok {
+		handlers = make([]RouteFunc, 0, len(r.middlewares)+len(routes))
+		handlers = append(handlers, r.middlewares...)
+		handlers = append(handlers, routes...)
+	}
+	r.mutex.RUnlock()
+
+	if !ok {
+		return
+	}
+	ctx := newContext(r, sess, cmd, body, handlers)
+	ctx.Next()
+}
@@ -0,0 +1,140 @@
+package libnet2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// 只实现测试需要的方法
+type routeTestSession struct {
+	Session2Interface
+	sent   [][]byte
+	closed bool
+}
+
+func (s *routeTestSession) Send(val []byte) error {
+	s.sent = append(s.sent, val)
+	return nil
+}
+
+func (s *routeTestSession) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *routeTestSession) GetUniqueID() uint64 {
+	return 1
+}
+
+func TestRouterDispatch(t *testing.T) {
+	router := NewRouter(nil)
+	trace := make([]string, 0)
+	router.Use(func(ctx *Context) {
+		trace = append(trace, "before")
+		ctx.Next()
+		trace = append(trace, "after")
+	})
+	router.Handle(1, func(ctx *Context) {
+		trace = append(trace, "cmd1")
+		ctx.Reply(append([]byte("re:"), ctx.Body...))
+	})
+
+	sess := new(routeTestSession)
+	val, _ := router.Codec().Encode(1, []byte("hi"))
+	router.OnRecv(sess, val)
+
+	if want := []string{"before", "cmd1", "after"}; len(trace) != len(want) || trace[0] != want[0] || trace[1] != want[1] || trace[2] != want[2] {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+	if len(sess.sent) != 1 {
+		t.Fatalf("expected one reply, got %d", len(sess.sent))
+	}
+	cmd, body, err := router.Codec().Decode(sess.sent[0])
+	if err != nil || cmd != 1 || !bytes.Equal(body, []byte("re:hi")) {
+		t.Fatalf("unexpected reply cmd [%d] body [%s] err [%v]", cmd, body, err)
+	}
+}
+
+func TestRouterAuthAndRecovery(t *testing.T) {
+	router := NewRouter(nil)
+	router.Use(Recovery(nil), Auth(func(ctx *Context) bool { return false }, 2))
+	called := 0
+	router.Handle(1, func(ctx *Context) { called++ })
+	router.Handle(2, func(ctx *Context) { panic("boom") })
+
+	sess := new(routeTestSession)
+	val, _ := router.Codec().Encode(1, nil)
+	router.OnRecv(sess, val)
+	if called != 0 {
+		t.Fatalf("auth should abort cmd 1")
+	}
+
+	val, _ = router.Codec().Encode(2, nil)
+	router.OnRecv(sess, val)
+	if !sess.closed {
+		t.Fatalf("recovery should close the session")
+	}
+
+	var routeErr error
+	router.OnError(func(sess Session2Interface, err error) { routeErr = err })
+	router.OnRecv(sess, []byte{1})
+	if routeErr != ErrRouteCmdShort {
+		t.Fatalf("unexpected error %v", routeErr)
+	}
+}
+
+type typedReq struct {
+	Name string `json:"name" msgpack:"name"`
+}
+
+type typedResp struct {
+	Greeting string `json:"greeting" msgpack:"greeting"`
+}
+
+func TestRouterTyped(t *testing.T) {
+	for _, serializer := range []Serializer{NewJSONSerializer(), NewMsgpackSerializer()} {
+		router := NewRouter(nil)
+		router.SetSerializer(serializer)
+		var routeErr error
+		router.OnError(func(sess Session2Interface, err error) { routeErr = err })
+		router.Handle(1, Typed(func(ctx *Context, req *typedReq) (*typedResp, error) {
+			return &typedResp{Greeting: "hello " + req.Name}, nil
+		}))
+
+		sess := new(routeTestSession)
+		body, _ := serializer.Marshal(&typedReq{Name: "bob"})
+		val, _ := router.Codec().Encode(1, body)
+		router.OnRecv(sess, val)
+		if routeErr != nil || len(sess.sent) != 1 {
+			t.Fatalf("expected one reply, got %d err %v", len(sess.sent), routeErr)
+		}
+		_, body, _ = router.Codec().Decode(sess.sent[0])
+		resp := new(typedResp)
+		if err := serializer.Unmarshal(body, resp); err != nil || resp.Greeting != "hello bob" {
+			t.Fatalf("unexpected reply %+v err %v", resp, err)
+		}
+
+		val, _ = router.Codec().Encode(1, []byte{0xc1})
+		router.OnRecv(sess, val)
+		if routeErr == nil || len(sess.sent) != 1 {
+			t.Fatalf("bad body should report error without reply")
+		}
+	}
+}
+
+func TestProtobufSerializer(t *testing.T) {
+	serializer := NewProtobufSerializer()
+	body, err := serializer.Marshal(&wrappers.StringValue{Value: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := new(wrappers.StringValue)
+	if err = serializer.Unmarshal(body, val); err != nil || val.Value != "hi" {
+		t.Fatalf("unexpected value %v err %v", val, err)
+	}
+	if _, err = serializer.Marshal(&typedReq{}); err != ErrNotProtoMessage {
+		t.Fatalf("expected not proto message, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package libnet2
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// rpc帧的类型
+type RPCKind byte
+
+const (
+	// 请求
+	RPCRequest RPCKind = iota + 1
+	// 正常的回复
+	RPCResponse
+	// 出错的回复，包体是错误信息
+	RPCError
+)
+
+// 对端处理请求的回调，和 OnRecv 一样在session的处理协程中执行
+// 返回的错误会以 RPCError 回复给调用方，发送队列满的时候回复被丢弃，交给 OnSessError
+type OnSessCall func(sess Session2Interface, req []byte) ([]byte, error)
+
+// rpc帧的编码策略
+// 和心跳包一样，rpc帧和普通的包共用一个连接，Decode 认不出来的包交给上层
+type RPCCodec interface {
+	Encode(kind RPCKind, seq uint32, body []byte) []byte
+	Decode(val []byte) (kind RPCKind, seq uint32, body []byte, ok bool)
+}
+
+// 对端返回的错误
+type CallError struct {
+	Msg string
+}
+
+func (e *CallError) Error() string {
+	return e.Msg
+}
+
+// 默认的rpc编码，包头是固定的标记，1个字节的类型，4个字节大端的序号
+// 普通的包不能以这个标记开头
+type defaultRPCCodec struct {
+	magic []byte
+}
+
+// 新建默认的rpc编码，magic为空的时候使用 0xFF 0xFE
+func NewRPCCodec(magic []byte) RPCCodec {
+	c := new(defaultRPCCodec)
+	if len(magic) == 0 {
+		magic = []byte{0xFF, 0xFE}
+	}
+	c.magic = magic
+	return c
+}
+
+func (c *defaultRPCCodec) Encode(kind RPCKind, seq uint32, body []byte) []byte {
+	head := len(c.magic) + 5
+	val := make([]byte, head+len(body))
+	copy(val, c.magic)
+	val[len(c.magic)] = byte(kind)
+	libio.PutUint32BE(val[len(c.magic)+1:head], seq)
+	copy(val[head:], body)
+	return val
+}
+
+func (c *defaultRPCCodec) Decode(val []byte) (RPCKind, uint32, []byte, bool) {
+	head := len(c.magic) + 5
+	if len(val) < head || !bytes.HasPrefix(val, c.magic) {
+		return 0, 0, nil, false
+	}
+	kind := RPCKind(val[len(c.magic)])
+	if kind < RPCRequest || kind > RPCError {
+		return 0, 0, nil, false
+	}
+	return kind, libio.GetUint32BE(val[len(c.magic)+1 : head]), val[head:], true
+}
+
+type rpcResult struct {
+	val []byte
+	err error
+}
+
+// 等待回复的调用
+type rpcPending struct {
+	mutex sync.Mutex
+	seq   uint32
+	calls map[uint32]chan *rpcResult
+}
+
+func newRPCPending() *rpcPending {
+	p := new(rpcPending)
+	p.calls = make(map[uint32]chan *rpcResult)
+	return p
+}
+
+func (p *rpcPending) add() (uint32, chan *rpcResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.seq++
+	ch := make(chan *rpcResult, 1)
+	p.calls[p.seq] = ch
+	return p.seq, ch
+}
+
+func (p *rpcPending) del(seq uint32) chan *rpcResult {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ch := p.calls[seq]
+	delete(p.calls, seq)
+	return ch
+}
+
+// 发送请求并等待回复，ctx控制超时，session关闭的时候返回 ErrSessionClosed
+// 请求不经过发送队列，直接写入连接，队列只有处理协程在取，处理请求的回调中再调用Call也不会卡住
+func (s *defaultSession) Call(ctx context.Context, req []byte) ([]byte, error) {
+	codec := s.option.RPC
+	if codec == nil {
+		return nil, ErrRPCDisabled
+	}
+	if s.closeFlag.Get() {
+		return nil, ErrSessionClosed
+	}
+	seq, ch := s.pending.add()
+	if err := s.write(codec.Encode(RPCRequest, seq, req)); err != nil {
+		s.pending.del(seq)
+		return nil, err
+	}
+	select {
+	case result := <-ch:
+		return result.val, result.err
+	case <-ctx.Done():
+		s.pending.del(seq)
+		return nil, ctx.Err()
+	case <-s.closeChan:
+		s.pending.del(seq)
+		return nil, ErrSessionClosed
+	}
+}
+
+// 在读取的协程中处理回复，不经过接收队列，处理协程在回调中等待回复的时候也能收到
+// 是回复的时候返回true
+func (s *defaultSession) rpcResponse(val []byte) bool {
+	codec := s.option.RPC
+	if codec == nil {
+		return false
+	}
+	kind, seq, body, ok := codec.Decode(val)
+	if !ok || kind == RPCRequest {
+		return false
+	}
+	ch := s.pending.del(seq)
+	if ch == nil {
+		// 调用方已经超时了
+		return true
+	}
+	if kind == RPCError {
+		ch <- &rpcResult{err: &CallError{Msg: string(body)}}
+	} else {
+		ch <- &rpcResult{val: body}
+	}
+	return true
+}
+
+// 处理对端的请求，是请求的时候返回true
+func (s *defaultSession) rpcRequest(val []byte) bool {
+	codec := s.option.RPC
+	if codec == nil {
+		return false
+	}
+	kind, seq, body, ok := codec.Decode(val)
+	if !ok || kind != RPCRequest {
+		return false
+	}
+	onCall := s.handler.onCall()
+	if onCall == nil {
+		s.rpcReply(codec.Encode(RPCError, seq, []byte(ErrRPCHandlerNull.Error())))
+		return true
+	}
+	resp, err := onCall(s, body)
+	if err != nil {
+		s.rpcReply(codec.Encode(RPCError, seq, []byte(err.Error())))
+	} else {
+		s.rpcReply(codec.Encode(RPCResponse, seq, resp))
+	}
+	return true
+}
+
+// 回复请求，在处理协程中不能阻塞等待发送队列，队列满的时候丢弃回复，调用方按超时处理
+func (s *defaultSession) rpcReply(val []byte) {
+	err := s.TrySend(val)
+	if err == nil || err == ErrSessionClosed {
+		return
+	}
+	if onError := s.handler.onSessError(); onError != nil {
+		onError(s, err)
+	}
+}
@@ -0,0 +1,168 @@
+package libnet2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRPCPair(onCall OnSessCall) (*defaultSession, *defaultSession) {
+	a, b := net.Pipe()
+	packet := NewLengthPacket(4, BigEndian, 1024)
+	option := func() *SessionOption2 {
+		option := DefaultSessionOption()
+		option.RPC = NewRPCCodec(nil)
+		return option
+	}
+	server := NewHandler(packet)
+	server.OnCall = onCall
+	serverSess := newDefaultSession(a, option(), server)
+	clientSess := newDefaultSession(b, option(), NewHandler(packet))
+	serverSess.Accept()
+	clientSess.Accept()
+	return serverSess, clientSess
+}
+
+func TestRPCCall(t *testing.T) {
+	server, client := newRPCPair(func(sess Session2Interface, req []byte) ([]byte, error) {
+		switch string(req) {
+		case "fail":
+			return nil, errors.New("bad request")
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+		return []byte(strings.ToUpper(string(req))), nil
+	})
+	defer server.Close()
+
+	resp, err := client.Call(context.Background(), []byte("hello"))
+	if err != nil || string(resp) != "HELLO" {
+		t.Fatalf("expected HELLO, got %s %v", resp, err)
+	}
+
+	_, err = client.Call(context.Background(), []byte("fail"))
+	if e, ok := err.(*CallError); !ok || e.Msg != "bad request" {
+		t.Fatalf("expected call error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = client.Call(ctx, []byte("slow")); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// 超时的回复到达之后丢弃，不影响后面的调用
+	resp, err = client.Call(context.Background(), []byte("next"))
+	if err != nil || string(resp) != "NEXT" {
+		t.Fatalf("expected NEXT, got %s %v", resp, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), []byte("slow"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+	select {
+	case err = <-done:
+		if err != ErrSessionClosed {
+			t.Fatalf("expected session closed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call not failed after close")
+	}
+}
+
+func TestRPCReplyQueueFull(t *testing.T) {
+	a, b := net.Pipe()
+	packet := NewLengthPacket(4, BigEndian, 1024)
+	option := DefaultSessionOption()
+	option.RPC = NewRPCCodec(nil)
+	option.SendChanSize = 2
+	errChan := make(chan error, 4)
+	server := NewHandler(packet)
+	server.OnCall = func(sess Session2Interface, req []byte) ([]byte, error) {
+		if string(req) == "flood" {
+			// 处理协程还在回调中，队列没人取
+			sess.TrySend([]byte("1"))
+			sess.TrySend([]byte("2"))
+		}
+		return req, nil
+	}
+	server.OnSessError = func(sess Session2Interface, err error) {
+		errChan <- err
+	}
+	serverSess := newDefaultSession(a, option, server)
+	clientOption := DefaultSessionOption()
+	clientOption.RPC = NewRPCCodec(nil)
+	clientSess := newDefaultSession(b, clientOption, NewHandler(packet))
+	serverSess.Accept()
+	clientSess.Accept()
+	defer serverSess.Close()
+	defer clientSess.Close()
+
+	// 队列满的时候回复丢弃，不会卡住处理协程
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := clientSess.Call(ctx, []byte("flood")); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	select {
+	case err := <-errChan:
+		if err != ErrQueueFull {
+			t.Fatalf("expected queue full, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dropped reply not reported")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if resp, err := clientSess.Call(ctx, []byte("hello")); err != nil || string(resp) != "hello" {
+		t.Fatalf("expected hello, got %s %v", resp, err)
+	}
+}
+
+func TestRPCNestedCall(t *testing.T) {
+	a, b := net.Pipe()
+	packet := NewLengthPacket(4, BigEndian, 1024)
+	option := func() *SessionOption2 {
+		option := DefaultSessionOption()
+		option.RPC = NewRPCCodec(nil)
+		return option
+	}
+	server := NewHandler(packet)
+	server.OnCall = func(sess Session2Interface, req []byte) ([]byte, error) {
+		// 在处理协程中反过来调用对端
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := sess.Call(ctx, append([]byte("inner:"), req...))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte("outer:"), resp...), nil
+	}
+	client := NewHandler(packet)
+	client.OnCall = func(sess Session2Interface, req []byte) ([]byte, error) {
+		return []byte(strings.ToUpper(string(req))), nil
+	}
+	serverSess := newDefaultSession(a, option(), server)
+	clientSess := newDefaultSession(b, option(), client)
+	if serverSess.option.SendChanSize <= 1 {
+		t.Fatal("expected send queue")
+	}
+	serverSess.Accept()
+	clientSess.Accept()
+	defer serverSess.Close()
+	defer clientSess.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := clientSess.Call(ctx, []byte("hi"))
+	if err != nil || string(resp) != "outer:INNER:HI" {
+		t.Fatalf("expected outer:INNER:HI, got %s %v", resp, err)
+	}
+}
@@ -0,0 +1,121 @@
+package libnet2
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+)
+
+// 包体的序列化策略，路由用它把包体解析成结构体，以及把回复的结构体编码
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// json序列化
+type jsonSerializer struct {
+}
+
+func NewJSONSerializer() Serializer {
+	return new(jsonSerializer)
+}
+
+func (s *jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (s *jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// protobuf序列化，值必须是生成的 proto.Message
+type protobufSerializer struct {
+}
+
+func NewProtobufSerializer() Serializer {
+	return new(protobufSerializer)
+}
+
+func (s *protobufSerializer) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(msg)
+}
+
+func (s *protobufSerializer) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// msgpack序列化
+type msgpackSerializer struct {
+}
+
+func NewMsgpackSerializer() Serializer {
+	return new(msgpackSerializer)
+}
+
+func (s *msgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (s *msgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+var (
+	contextType = reflect.TypeOf((*Context)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// 把带类型的处理函数转成路由的处理函数
+// fn 的格式为 func(ctx *Context, req *Req) (resp *Resp, err error) 或者 func(ctx *Context, req *Req) error
+// 包体按照路由的序列化策略解析成 req，resp 不为nil的时候用同样的命令号回复
+// 解析和处理返回的错误交给 Router.OnError
+func Typed(fn interface{}) RouteFunc {
+	fnVal := reflect.ValueOf(fn)
+	fnType := fnVal.Type()
+	if fnType.Kind() != reflect.Func || fnType.NumIn() != 2 || fnType.In(0) != contextType || fnType.In(1).Kind() != reflect.Ptr {
+		panic(fmt.Errorf("typed route handler must be func(*Context, *Req), got %s", fnType))
+	}
+	numOut := fnType.NumOut()
+	if numOut < 1 || numOut > 2 || fnType.Out(numOut-1) != errorType {
+		panic(fmt.Errorf("typed route handler must return error or (resp, error), got %s", fnType))
+	}
+	reqType := fnType.In(1).Elem()
+
+	return func(ctx *Context) {
+		req := reflect.New(reqType)
+		if err := ctx.Bind(req.Interface()); err != nil {
+			ctx.router.error(ctx.Session, err)
+			return
+		}
+		out := fnVal.Call([]reflect.Value{reflect.ValueOf(ctx), req})
+		if err, _ := out[numOut-1].Interface().(error); err != nil {
+			ctx.router.error(ctx.Session, err)
+			return
+		}
+		if numOut == 1 || isNilValue(out[0]) {
+			return
+		}
+		if err := ctx.ReplyValue(out[0].Interface()); err != nil {
+			ctx.router.error(ctx.Session, err)
+		}
+	}
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
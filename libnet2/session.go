@@ -1,8 +1,8 @@
 package libnet2
 
 import (
+	"bufio"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"strings"
@@ -23,42 +23,110 @@ type defaultSession struct {
 	conn   net.Conn
 	//已经超时的次数
 	timeoutTimes *concurrent.AtomicInt32
+	// 最后一次读到和写出数据的时间，纳秒
+	lastRead  *concurrent.AtomicInt64
+	lastWrite *concurrent.AtomicInt64
+	// 已经触发过空闲的时间点，同一段空闲只触发一次，只在chanLoop中使用
+	readIdleAt  int64
+	writeIdleAt int64
+	allIdleAt   int64
+	// 直接写入的时候，多个协程可能同时写
+	writeMutex sync.Mutex
+	// 等待回复的rpc调用
+	pending *rpcPending
+	// 这个session的统计，累加到服务或者客户端的统计中
+	metrics *netMetrics
+	// 收包的速率限制
+	recvLimiter *TokenBucket
 
 	//释放的时候，为释放服务,保证一个session只被释放一次
 	disposeOnce sync.Once
 
+	// 服务的回调以及解析对象
+	handler *Handler
+
+	// 服务内部使用的关闭回调
 	onClose OnSessClose
-	onError OnSessError
-	onRecv  OnSessRecv
+	// 通过Recv单独设置的接收回调，优先于handler
+	onRecv OnSessRecv
 
 	closeFlag *concurrent.AtomicBoolean
+	// 保证关闭的信号只发一次
+	closeOnce sync.Once
+	// 优雅关闭中
+	drainFlag *concurrent.AtomicBoolean
+	drainOnce sync.Once
 
 	recvChan  chan []byte
 	sendChan  chan []byte
+	// 队列是否达到过高水位
+	recvHigh  *concurrent.AtomicBoolean
+	sendHigh  *concurrent.AtomicBoolean
 	closeChan chan bool
+	drainChan chan bool
+	// 读取的协程已经退出
+	recvDone chan bool
 
 	reader *libio.Reader
 	writer *libio.Writer
+	// 批量写入，没有开启的时候为nil
+	batch    *batchWriter
+	batchOut *libio.Writer
 }
 
 func init() {
 	globalSessionId = concurrent.NewAtomicUint64(0)
 }
 
-func newDefaultSession(conn net.Conn, sessionOption *SessionOption2) *defaultSession {
+func newDefaultSession(conn net.Conn, sessionOption *SessionOption2, handler *Handler) *defaultSession {
 	sess := new(defaultSession)
 	sess.option = sessionOption
+	sess.handler = handler
 	sess.check()
 	sess.conn = conn
 	sess.params = concurrent.NewCocurrentMap()
 	sess.id = globalSessionId.IncrementAndGet()
 	sess.timeoutTimes = concurrent.NewAtomicInt32(0)
+	now := time.Now().UnixNano()
+	sess.lastRead = concurrent.NewAtomicInt64(now)
+	sess.lastWrite = concurrent.NewAtomicInt64(now)
 	sess.closeFlag = concurrent.NewAtomicBoolean(false)
 	sess.recvChan = make(chan []byte, sess.option.RecvChanSize)
 	sess.sendChan = make(chan []byte, sess.option.SendChanSize)
-	sess.closeChan = make(chan bool, 1)
-	sess.reader = libio.NewReader(conn)
+	sess.pending = newRPCPending()
+	sess.metrics = newNetMetrics(metrics)
+	if sess.option.RecvRate > 0 {
+		sess.recvLimiter = NewTokenBucket(sess.option.RecvRate, sess.option.RecvBurst)
+	}
+	sess.recvHigh = concurrent.NewAtomicBoolean(false)
+	sess.sendHigh = concurrent.NewAtomicBoolean(false)
+	sess.drainFlag = concurrent.NewAtomicBoolean(false)
+	sess.closeChan = make(chan bool)
+	sess.drainChan = make(chan bool)
+	sess.recvDone = make(chan bool)
+	if _, ok := conn.(MessageConn); ok {
+		// 按消息读取的连接不能加缓冲
+		sess.reader = libio.NewReader(conn)
+	} else {
+		// 带缓冲读取，分隔符之类按字节读取的策略不会每次都去读连接
+		sess.reader = libio.NewReader(bufio.NewReader(conn))
+	}
 	sess.writer = libio.NewWriter(conn)
+	if ws, ok := conn.(*wsConn); ok {
+		// websocket按照包的最大长度限制一条消息，不会按照对端说的长度去分配
+		ws.setReadLimit(frameLimit(handler.packet()))
+	}
+	if sess.option.FramePool != nil {
+		sess.reader.SetPool(sess.option.FramePool)
+	}
+	if _, ok := conn.(MessageConn); !ok && sess.option.WriteBatch > 1 {
+		pool := sess.option.FramePool
+		if pool == nil {
+			pool = writePool
+		}
+		sess.batch = newBatchWriter(pool)
+		sess.batchOut = libio.NewWriter(sess.batch)
+	}
 	return sess
 }
 
@@ -72,34 +140,81 @@ func (s *defaultSession) check() {
 	if s.option.SendChanSize < 1 {
 		s.option.SendChanSize = 1
 	}
+	if s.option.SendPolicy < OverflowBlock || s.option.SendPolicy > OverflowDisconnect {
+		s.option.SendPolicy = OverflowBlock
+	}
+	if s.option.RecvPolicy < OverflowBlock || s.option.RecvPolicy > OverflowDisconnect {
+		s.option.RecvPolicy = OverflowBlock
+	}
+	if s.option.HighWatermark < 0 {
+		s.option.HighWatermark = 0
+	}
+	if s.option.LowWatermark >= s.option.HighWatermark {
+		// 低水位要比高水位低，否则回落的回调和高水位同时触发
+		s.option.LowWatermark = s.option.HighWatermark - 1
+	}
+	if s.option.LowWatermark < 0 {
+		s.option.LowWatermark = 0
+	}
 }
 
-//  发送数据
+//  发送数据，队列满的时候按照 SendPolicy 处理
 func (s *defaultSession) Send(val []byte) error {
+	return s.send(val, true)
+}
+
+// 不阻塞的发送，队列满的时候返回 ErrQueueFull
+// 没有发送队列的时候直接写入连接
+func (s *defaultSession) TrySend(val []byte) error {
+	return s.send(val, false)
+}
+
+func (s *defaultSession) send(val []byte, block bool) error {
 	if s.closeFlag.Get() {
-		return fmt.Errorf("conn closed")
-	}
-	if ServerPacket == nil {
-		panic(errors.New("服务的解析对象不能为nil"))
+		return ErrSessionClosed
 	}
 	if s.option.SendChanSize > 1 {
-		s.sendChan <- val
-	} else {
-		return ServerPacket.Write(s.writer, val)
+		return s.push(QueueSend, val, block)
 	}
-	return nil
+	return s.write(val)
 }
 
-// 关闭
+// 关闭，可以重复调用，真正的释放在chanLoop中执行
 func (s *defaultSession) Close() error {
-	// 已经关闭
-	if s.closeFlag.Get() {
-		return nil
-	}
-	s.closeChan <- true
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
 	return nil
 }
 
+// 优雅关闭，不再读取新的数据，处理完已经收到的信息，发送完队列中的数据之后关闭
+func (s *defaultSession) shutdown() {
+	s.drainOnce.Do(func() {
+		s.drainFlag.Set(true)
+		close(s.drainChan)
+	})
+}
+
+// 强制关闭，直接关闭连接，打断阻塞的写入
+func (s *defaultSession) forceClose() {
+	s.Close()
+	s.conn.Close()
+}
+
+// 是否有发送队列，没有的时候发送都是直接写连接
+func (s *defaultSession) queued() bool {
+	return s.option.SendChanSize > 1
+}
+
+func (s *defaultSession) stats() *netMetrics {
+	return s.metrics
+}
+
+// 服务内部使用的关闭回调
+func (s *defaultSession) setCloseHook(onClose OnSessClose) {
+	s.onClose = onClose
+}
+
 // 收到信息
 func (s *defaultSession) Recv(onRecv OnSessRecv) {
 	s.onRecv = onRecv
@@ -164,65 +279,101 @@ func (s *defaultSession) setUniqueID(id uint64) {
 }
 
 func (s *defaultSession) Accept() {
+	s.metrics.open(s)
 	go s.chanLoop()
 	go s.recvLoop()
 }
 
 func (s *defaultSession) chanLoop() {
-	defer s.Close()
+	defer s.close()
+	ticker := time.NewTicker(s.tickPeriod())
+	defer ticker.Stop()
 	for {
 		select {
-		case msg, flag := <-s.recvChan:
-			if !flag {
-				return
-			}
+		case msg := <-s.recvChan:
+			s.popped(QueueRecv)
+			s.recv(msg)
 
-			s.onRecv(s, msg)
-			s.timeoutTimes.Set(0)
+		case msg := <-s.sendChan:
+			s.popped(QueueSend)
+			s.writeQueued(msg)
 
-		case msg, flag := <-s.sendChan:
-
-			if !flag {
-				return
-			}
-
-			ServerPacket.Write(s.writer, msg)
-
-		case <-time.After(s.option.ReadTimeout):
-			t := s.timeoutTimes.IncrementAndGet()
-			if t > int32(s.option.ReadTimeoutTimes) {
+		case now := <-ticker.C:
+			if !s.tick(now) {
 				//直接关闭
 				return
 			}
 
-		case flag := <-s.closeChan:
-			if flag {
-				// 如果是关闭
-				s.close()
-			}
+		case <-s.closeChan:
+			return
+
+		case <-s.drainChan:
+			s.drain()
+			return
 		}
 	}
 }
 
-func (s *defaultSession) recvLoop() {
-
-	defer s.Close()
+// 优雅关闭时候的处理
+func (s *defaultSession) drain() {
+	// 打断阻塞的读取
+	s.conn.SetReadDeadline(time.Now())
+	// 等待读取的协程退出，期间继续处理收到的信息
+	for waiting := true; waiting; {
+		select {
+		case msg := <-s.recvChan:
+			s.popped(QueueRecv)
+			s.recv(msg)
+		case <-s.recvDone:
+			waiting = false
+		case <-s.closeChan:
+			return
+		}
+	}
 	for {
-		if ServerPacket == nil {
-			panic(errors.New("服务的解析对象不能为nil"))
+		select {
+		case msg := <-s.recvChan:
+			s.popped(QueueRecv)
+			s.recv(msg)
+		case msg := <-s.sendChan:
+			s.popped(QueueSend)
+			s.writeQueued(msg)
+		case <-s.closeChan:
+			return
+		default:
+			return
 		}
+	}
+}
 
-		data, err := ServerPacket.Read(s.reader)
+func (s *defaultSession) recvLoop() {
+	defer close(s.recvDone)
+	defer func() {
+		// 优雅关闭的时候由chanLoop负责关闭
+		if !s.drainFlag.Get() {
+			s.Close()
+		}
+	}()
+	for {
+		data, err := s.packet().Read(s.reader)
 		if err != nil {
+			if s.drainFlag.Get() || s.closeFlag.Get() {
+				return
+			}
 
 			if err == io.EOF || err == io.ErrUnexpectedEOF || strings.Contains(err.Error(), "use of closed network connection") {
 				// 这里表示session已经关闭
-
+				// 对端关闭的时候，已经收到的信息还要处理完
+				s.shutdown()
 				return
 			}
 
-			if s.onError != nil {
-				s.onError(s, err)
+			if onError := s.handler.onSessError(); onError != nil {
+				onError(s, err)
+			}
+			if isFatalError(err) {
+				// 连接已经不可用，或者后面的数据已经没法解析了
+				return
 			}
 			continue
 		}
@@ -230,25 +381,117 @@ func (s *defaultSession) recvLoop() {
 		if data == nil {
 			continue
 		}
+		s.lastRead.Set(time.Now().UnixNano())
+		s.metrics.recv(len(data))
+		if s.recvLimiter != nil && !s.recvLimiter.Allow() {
+			// 收包太快，直接关闭
+			if onError := s.handler.onSessError(); onError != nil {
+				onError(s, ErrRecvRateLimit)
+			}
+			return
+		}
+		if s.heartbeat(data) {
+			// 心跳包不交给上层
+			s.release(data)
+			continue
+		}
+		if s.rpcResponse(data) {
+			// rpc的回复交给调用方，不能放回缓冲池
+			continue
+		}
 
 		if s.option.RecvChanSize > 1 {
-			s.recvChan <- data
+			if err := s.push(QueueRecv, data, true); err != nil {
+				if err == ErrSessionClosed || s.option.RecvPolicy == OverflowDisconnect {
+					return
+				}
+				// 丢弃或者超时，通知上层之后继续读取
+				if onError := s.handler.onSessError(); onError != nil {
+					onError(s, err)
+				}
+			}
 		} else {
-			s.onRecv(s, data)
+			s.recv(data)
 		}
 	}
 }
 
+// 释放session，只在chanLoop退出的时候执行
+// 通道不关闭，避免其他协程往关闭的通道写数据，由gc回收
 func (s *defaultSession) close() error {
 	var err error
 
-	s.closeFlag.Set(true)
 	s.disposeOnce.Do(func() {
-		s.conn.Close() //关闭连接
-		close(s.closeChan)
-		close(s.recvChan)
-		close(s.sendChan)
+		s.closeFlag.Set(true)
+		s.Close()
+		err = s.conn.Close() //关闭连接
+		s.metrics.close(s)
+		if s.onClose != nil {
+			s.onClose(s)
+		}
+		if onClose := s.handler.onClose(); onClose != nil {
+			onClose(s)
+		}
 	})
-	s.onClose(s)
 	return err
 }
+
+// 写入连接，记录最后写出的时间
+func (s *defaultSession) write(val []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	err := s.packet().Write(s.writer, val)
+	if err == nil {
+		s.lastWrite.Set(time.Now().UnixNano())
+		s.metrics.send(len(val))
+	}
+	return err
+}
+
+// 写出发送队列中取出的包，开启批量写入的时候顺便带上队列中后面的包
+func (s *defaultSession) writeQueued(msg []byte) error {
+	if s.batch != nil {
+		return s.writeBatch(msg)
+	}
+	return s.write(msg)
+}
+
+// 解析对象，必须要有
+func (s *defaultSession) packet() PacketInterface {
+	packet := s.handler.packet()
+	if packet == nil {
+		panic(errors.New("服务的解析对象不能为nil"))
+	}
+	return packet
+}
+
+// 分发收到的信息
+func (s *defaultSession) recv(val []byte) {
+	start := time.Now()
+	defer func() {
+		s.metrics.handle(time.Since(start))
+		s.release(val)
+	}()
+	if s.rpcRequest(val) {
+		return
+	}
+	onRecv := s.onRecv
+	if onRecv == nil {
+		onRecv = s.handler.onRecv()
+	}
+	if onRecv != nil {
+		onRecv(s, val)
+	}
+}
+
+// 读取之后没法继续的错误
+// 连接本身的错误不会恢复，包太大的时候后面的数据已经没法解析
+func isFatalError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if _, ok := err.(*FrameSizeError); ok {
+		return true
+	}
+	return err == ErrNotMessageConn
+}
@@ -0,0 +1,99 @@
+package libnet2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libnet2"
+	"github.com/wuqifei/server_lib/libnet2/nettest"
+)
+
+// 不是服务创建的session
+type foreignSession struct {
+	libnet2.Session2Interface
+}
+
+func (foreignSession) GetUniqueID() uint64 {
+	return 1 << 62
+}
+
+// 处理 block 的时候一直阻塞到 release 关闭，其他的慢慢回复
+func newSlowServer(t *testing.T, release chan bool) *nettest.Server {
+	handler := new(libnet2.Handler)
+	handler.OnRecv = func(sess libnet2.Session2Interface, val []byte) {
+		if string(val) == "block" {
+			<-release
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		sess.Send(append([]byte(nil), val...))
+	}
+	s, err := nettest.NewServer(libnet2.NewLengthPacket(2, libnet2.BigEndian, 1024), nil, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestShutdownDrain(t *testing.T) {
+	s := newSlowServer(t, nil)
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Recorder.Wait(nettest.EventRecv, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 正在处理的信息处理完，回复发出去之后才关闭
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Server.Shutdown(ctx); err != nil {
+		t.Fatalf("expected drained, got %v", err)
+	}
+	if err = c.Expect([]byte("hello"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WaitClose(time.Second); err == nil || err == nettest.ErrTimeout {
+		t.Fatalf("expected closed, got %v", err)
+	}
+	if n := s.Recorder.Count(nettest.EventClose); n != 1 {
+		t.Fatalf("expected 1 close, got %d", n)
+	}
+}
+
+func TestShutdownForce(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+	s := newSlowServer(t, release)
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Send([]byte("block")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Recorder.Wait(nettest.EventRecv, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// 使用者自己加到hub中的session不影响关闭
+	s.Server.Hub().Add(foreignSession{})
+
+	// 回调一直阻塞，ctx到期之后强制关闭连接并且马上返回
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = s.Server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatalf("shutdown returned after %v", cost)
+	}
+	if err = c.WaitClose(time.Second); err == nil || err == nettest.ErrTimeout {
+		t.Fatalf("expected closed, got %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package tcp_server
 
 import (
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/wuqifei/server_lib/libio"
@@ -14,39 +13,34 @@ var (
 )
 
 func NewServer() {
-	server, _ = libnet2.New()
+	handler := libnet2.NewHandler(new(packet))
 
-	server.Run()
-	libnet2.ServerSessionBlock = func(sess libnet2.Session2Interface) {
+	handler.OnSession = func(sess libnet2.Session2Interface) {
 		fmt.Printf("sess [%d] \n", sess.GetUniqueID())
 		sess.Send([]byte("hello new connect"))
 	}
 
-	libnet2.ServerErrorBlock = func(err error) {
+	handler.OnError = func(err error) {
 		fmt.Printf("error of block [%v]\n", err)
 	}
 
-	libnet2.SessionCloseBlock = func(sess libnet2.Session2Interface) {
+	handler.OnClose = func(sess libnet2.Session2Interface) {
 		fmt.Printf("session [%d]closed \n", sess.GetUniqueID())
 	}
 
-	libnet2.SessionErrorBlock = func(sess libnet2.Session2Interface, err error) {
+	handler.OnSessError = func(sess libnet2.Session2Interface, err error) {
 
 		fmt.Printf("session [%d] error of block [%v]\n", sess.GetUniqueID(), err)
 	}
-	libnet2.ServerPacket = new(packet)
 
 	// 收到信息
-	libnet2.SessionRecvBlock = func(sess libnet2.Session2Interface, val []byte) {
+	handler.OnRecv = func(sess libnet2.Session2Interface, val []byte) {
 		fmt.Printf("[%v]:server sess[%d] recv:[%s] \n", time.Now(), sess.GetUniqueID(), string(val))
 		sess.Send([]byte("hello there"))
-		once := sync.Once{}
-		once.Do(func() {
-
-			server.UpdateSessionID(1, 2)
-		})
 	}
 
+	server, _ = libnet2.NewWithOption(libnet2.DefaultOption(), libnet2.DefaultSessionOption(), handler)
+	server.Run()
 }
 
 type packet struct {
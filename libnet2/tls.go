@@ -0,0 +1,152 @@
+package libnet2
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 证书的热加载，证书文件更新之后，新的连接使用新的证书
+// 已经建立的连接不受影响
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	stopOnce sync.Once
+	stopChan chan bool
+}
+
+// 新建证书的热加载，会先加载一次
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := new(CertReloader)
+	r.certFile = certFile
+	r.keyFile = keyFile
+	r.stopChan = make(chan bool)
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 重新加载证书
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	modTime := r.lastModTime()
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mutex.Unlock()
+	return nil
+}
+
+// 设置到 tls.Config.GetCertificate 上
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// 定时检查证书文件的修改时间，有变化就重新加载
+// onError 为加载失败的回调，失败的时候继续使用旧的证书
+func (r *CertReloader) Watch(interval time.Duration, onError OnError) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopChan:
+				return
+			case <-ticker.C:
+				r.mutex.RLock()
+				modTime := r.modTime
+				r.mutex.RUnlock()
+				if !r.lastModTime().After(modTime) {
+					continue
+				}
+				if err := r.Reload(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// 停止检查
+func (r *CertReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+	})
+}
+
+// 证书和私钥中最后修改的时间
+func (r *CertReloader) lastModTime() time.Time {
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime
+}
+
+// 新建服务端的tls配置
+// clientCAFile 不为空的时候，要求客户端提供证书，并用这个ca验证
+// reloadInterval 大于0的时候，定时检查证书文件并热加载
+func NewTLSConfig(certFile, keyFile, clientCAFile string, reloadInterval time.Duration) (*tls.Config, *CertReloader, error) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := new(tls.Config)
+	config.GetCertificate = reloader.GetCertificate
+
+	if clientCAFile != "" {
+		pool, err := LoadCertPool(clientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if reloadInterval > 0 {
+		reloader.Watch(reloadInterval, nil)
+	}
+	return config, reloader, nil
+}
+
+// 从pem文件加载证书池，用于验证对端
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("libnet2:no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// 对端的证书，不是tls连接的时候返回错误
+// 服务端的session在握手之后才会回调，所以在回调中可以直接获取
+func PeerCertificates(sess Session2Interface) ([]*x509.Certificate, error) {
+	conn, ok := sess.GetConn().(*tls.Conn)
+	if !ok {
+		return nil, ErrNotTLS
+	}
+	return conn.ConnectionState().PeerCertificates, nil
+}
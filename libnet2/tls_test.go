@@ -0,0 +1,203 @@
+package libnet2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试用的证书，ca为nil的时候自签名
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, serial int64, ca *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// 写成pem文件，返回证书和私钥的路径
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err = os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLSServer(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", 1, nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "server-1", 2, ca).write(t, dir, "server")
+	client := newTestCert(t, "client", 3, ca)
+
+	config, reloader, err := NewTLSConfig(certFile, keyFile, caFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Stop()
+
+	option := DefaultOption()
+	option.Address = "127.0.0.1:0"
+	option.TLSConfig = config
+	option.TLSHandshakeTimeout = time.Second
+	packet := NewLengthPacket(2, BigEndian, 1024)
+	handler := NewHandler(packet)
+	peerChan := make(chan string, 4)
+	errChan := make(chan error, 4)
+	handler.OnSession = func(sess Session2Interface) {
+		certs, err := PeerCertificates(sess)
+		if err != nil || len(certs) == 0 {
+			errChan <- err
+			return
+		}
+		peerChan <- certs[0].Subject.CommonName
+	}
+	handler.OnError = func(err error) {
+		errChan <- err
+	}
+	server, err := NewWithOption(option, DefaultSessionOption(), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Run()
+
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 返回服务端证书的名字
+	dial := func(certs ...tls.Certificate) (string, error) {
+		clientConfig := &tls.Config{RootCAs: pool, Certificates: certs}
+		conn, err := tls.Dial("tcp", server.Listener().Addr().String(), clientConfig)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	// 客户端证书通过验证，回调中可以拿到客户端的证书
+	name, err := dial(client.tlsCert())
+	if err != nil || name != "server-1" {
+		t.Fatalf("expected server-1, got %q %v", name, err)
+	}
+	select {
+	case peer := <-peerChan:
+		if peer != "client" {
+			t.Fatalf("expected client cert, got %q", peer)
+		}
+	case err := <-errChan:
+		t.Fatalf("unexpected error %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("session not accepted")
+	}
+
+	// 没有客户端证书的连接在握手的时候被拒绝
+	dial()
+	select {
+	case peer := <-peerChan:
+		t.Fatalf("unexpected session from %q", peer)
+	case <-errChan:
+	case <-time.After(time.Second):
+		t.Fatal("handshake not rejected")
+	}
+
+	// 证书文件更新之后，新的连接使用新的证书
+	newTestCert(t, "server-2", 4, ca).write(t, dir, "server")
+	if err = reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	name, err = dial(client.tlsCert())
+	if err != nil || name != "server-2" {
+		t.Fatalf("expected server-2, got %q %v", name, err)
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", 1, nil)
+	certFile, keyFile := newTestCert(t, "old", 2, ca).write(t, dir, "server")
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Stop()
+	reloader.Watch(10*time.Millisecond, nil)
+
+	// 修改时间要比上次加载的晚
+	newTestCert(t, "new", 3, ca).write(t, dir, "server")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cert, _ := reloader.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Subject.CommonName == "new" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
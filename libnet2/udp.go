@@ -0,0 +1,394 @@
+package libnet2
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// udp包的最大长度
+	maxDatagramSize = 65535
+	// 每个对端缓存的还没处理的udp包，超过的直接丢弃
+	udpRecvChanSize = 128
+	// 等待 Accept 的新对端，超过的时候丢掉新对端的包，对端重发的时候再建立
+	udpAcceptBacklog = 128
+)
+
+// 是否是udp的网络类型
+func isUDPNetwork(network string) bool {
+	return strings.HasPrefix(network, "udp")
+}
+
+// udp的监听，按照对端的地址生成伪连接
+// 第一次收到某个地址的包时，Accept返回这个地址的连接，后面的流程和tcp完全一样
+// udp没有关闭的通知，对端的连接依靠session的读取超时来释放
+// 读取的协程不会等待 Accept，处理不过来的新对端直接丢弃，不影响已经建立的对端
+type udpListener struct {
+	pc     net.PacketConn
+	option *ARQOption
+
+	mutex sync.Mutex
+	peers map[string]*udpConn
+	// 可靠udp关闭的对端，重传时间内迟到的包直接丢弃，不会建立新的连接，值为过期的时间
+	closed    map[string]time.Time
+	lastPrune time.Time
+
+	connChan  chan net.Conn
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+func newUDPListener(pc net.PacketConn, option *ARQOption) *udpListener {
+	l := new(udpListener)
+	l.pc = pc
+	l.option = option
+	l.peers = make(map[string]*udpConn)
+	l.closed = make(map[string]time.Time)
+	l.connChan = make(chan net.Conn, udpAcceptBacklog)
+	l.closeChan = make(chan bool)
+	go l.readLoop()
+	return l
+}
+
+func (l *udpListener) readLoop() {
+	defer l.Close()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		val := make([]byte, n)
+		copy(val, buf[:n])
+
+		key := addr.String()
+		l.mutex.Lock()
+		peer, ok := l.peers[key]
+		if !ok && (!l.acceptable(key, val) || len(l.connChan) >= cap(l.connChan)) {
+			// Accept 跟不上的时候，例如达到了 MaxConn，丢掉新对端的包
+			l.mutex.Unlock()
+			continue
+		}
+		if !ok {
+			peer = newUDPConn(l, addr)
+			l.peers[key] = peer
+		}
+		l.mutex.Unlock()
+
+		if !ok {
+			var conn net.Conn = peer
+			if l.option != nil {
+				conn = newARQConn(peer, l.option)
+			}
+			// 只有这个协程放入，上面检查过不会阻塞
+			l.connChan <- conn
+		}
+		peer.push(val)
+	}
+}
+
+// 没有连接的对端发来的包是否可以建立新的连接，需要持有锁
+// 可靠udp只有数据包才建立连接，关闭之后收到的确认和重传的数据直接丢弃
+func (l *udpListener) acceptable(key string, val []byte) bool {
+	if l.option == nil {
+		return true
+	}
+	if len(val) < arqHeadSize || val[0] != arqCmdData {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(l.lastPrune) >= l.linger() {
+		l.lastPrune = now
+		for k, until := range l.closed {
+			if now.After(until) {
+				delete(l.closed, k)
+			}
+		}
+	}
+	if until, ok := l.closed[key]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(l.closed, key)
+	}
+	return true
+}
+
+// 对端最后一次重传可能到达的时间
+func (l *udpListener) linger() time.Duration {
+	return l.option.RetransmitInterval * time.Duration(l.option.RetransmitTimes+1)
+}
+
+func (l *udpListener) remove(conn *udpConn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := conn.addr.String()
+	if l.peers[key] != conn {
+		return
+	}
+	delete(l.peers, key)
+	if l.option != nil {
+		l.closed[key] = time.Now().Add(l.linger())
+	}
+}
+
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connChan:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, errClosed
+	}
+}
+
+// 关闭监听，udp所有的对端共用一个socket，已经建立的session也没法再收发数据
+func (l *udpListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+		err = l.pc.Close()
+	})
+	return err
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// 服务端一个对端地址的伪连接
+type udpConn struct {
+	listener *udpListener
+	addr     net.Addr
+
+	recvChan chan []byte
+	stream   messageStream
+	deadline *readDeadline
+
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+func newUDPConn(listener *udpListener, addr net.Addr) *udpConn {
+	c := new(udpConn)
+	c.listener = listener
+	c.addr = addr
+	c.recvChan = make(chan []byte, udpRecvChanSize)
+	c.deadline = newReadDeadline()
+	c.closeChan = make(chan bool)
+	return c
+}
+
+// 收到对端的包，处理不过来的时候和udp一样直接丢弃
+func (c *udpConn) push(val []byte) {
+	select {
+	case c.recvChan <- val:
+	default:
+	}
+}
+
+func (c *udpConn) ReadMessage() ([]byte, error) {
+	select {
+	case val := <-c.recvChan:
+		return val, nil
+	case <-c.closeChan:
+		return nil, errClosed
+	case <-c.listener.closeChan:
+		return nil, errClosed
+	case <-c.deadline.wait():
+		return nil, errTimeout
+	}
+}
+
+func (c *udpConn) WriteMessage(b []byte) error {
+	select {
+	case <-c.closeChan:
+		return errClosed
+	default:
+	}
+	_, err := c.listener.pc.WriteTo(b, c.addr)
+	return err
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	return c.stream.read(b, c.ReadMessage)
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	if err := c.WriteMessage(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *udpConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.listener.remove(c)
+	})
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.listener.pc.LocalAddr()
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.deadline.set(t)
+	return nil
+}
+
+// udp的写入不会阻塞
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// 客户端的udp连接，一次Read和Write就是一个udp包
+type udpDialConn struct {
+	net.Conn
+	stream messageStream
+	// 读取用的缓冲，同时只有一个协程在读
+	buf []byte
+}
+
+func newUDPDialConn(conn net.Conn) *udpDialConn {
+	c := new(udpDialConn)
+	c.Conn = conn
+	return c
+}
+
+// 每个包按实际的长度复制一份，放在接收窗口或者队列中的小包不会占着整个缓冲
+func (c *udpDialConn) ReadMessage() ([]byte, error) {
+	if c.buf == nil {
+		c.buf = make([]byte, maxDatagramSize)
+	}
+	n, err := c.Conn.Read(c.buf)
+	if err != nil {
+		return nil, err
+	}
+	val := make([]byte, n)
+	copy(val, c.buf[:n])
+	return val, nil
+}
+
+func (c *udpDialConn) WriteMessage(b []byte) error {
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+func (c *udpDialConn) Read(b []byte) (int, error) {
+	return c.stream.read(b, c.ReadMessage)
+}
+
+// 客户端连接udp服务
+func dialUDP(option *ClientOption) (net.Conn, error) {
+	conn, err := net.DialTimeout(option.Network, option.Address, option.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	udpConn := newUDPDialConn(conn)
+	if option.ARQ != nil {
+		return newARQConn(udpConn, option.ARQ), nil
+	}
+	return udpConn, nil
+}
+
+// 把按消息读取转成按流读取，一条消息没读完的时候，剩下的留给下一次
+type messageStream struct {
+	pending []byte
+}
+
+func (m *messageStream) read(b []byte, next func() ([]byte, error)) (int, error) {
+	for len(m.pending) == 0 {
+		val, err := next()
+		if err != nil {
+			return 0, err
+		}
+		m.pending = val
+	}
+	n := copy(b, m.pending)
+	m.pending = m.pending[n:]
+	return n, nil
+}
+
+// 读取的超时，设置之后唤醒阻塞的读取，和net.Pipe的实现一样
+type readDeadline struct {
+	mutex  sync.Mutex
+	timer  *time.Timer
+	cancel chan bool
+}
+
+func newReadDeadline() *readDeadline {
+	d := new(readDeadline)
+	d.cancel = make(chan bool)
+	return d
+}
+
+func (d *readDeadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// 计时器已经触发了，等待关闭完成
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isChanClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan bool)
+		}
+		return
+	}
+	dur := time.Until(t)
+	if dur <= 0 {
+		if !closed {
+			close(d.cancel)
+		}
+		return
+	}
+	if closed {
+		d.cancel = make(chan bool)
+	}
+	cancel := d.cancel
+	d.timer = time.AfterFunc(dur, func() {
+		close(cancel)
+	})
+}
+
+func (d *readDeadline) wait() chan bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.cancel
+}
+
+func isChanClosed(c chan bool) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// 连接的错误，实现net.Error，session读到之后会关闭
+type netError struct {
+	msg     string
+	timeout bool
+}
+
+func (e *netError) Error() string   { return e.msg }
+func (e *netError) Timeout() bool   { return e.timeout }
+func (e *netError) Temporary() bool { return e.timeout }
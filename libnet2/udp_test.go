@@ -0,0 +1,127 @@
+package libnet2
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libio"
+)
+
+func newTestUDPListener(t *testing.T, option *ARQOption) *udpListener {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newUDPListener(pc, option)
+}
+
+func dialTestUDP(t *testing.T, l *udpListener) net.Conn {
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func acceptTimeout(l *udpListener, timeout time.Duration) net.Conn {
+	select {
+	case conn := <-l.connChan:
+		return conn
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// 没有调用 Accept 的时候，新的对端不会卡住已经建立的对端
+func TestUDPListenerAcceptStalled(t *testing.T) {
+	l := newTestUDPListener(t, nil)
+	defer l.Close()
+	first := dialTestUDP(t, l)
+	defer first.Close()
+	first.Write([]byte("hello"))
+	conn := acceptTimeout(l, time.Second)
+	if conn == nil {
+		t.Fatal("first peer not accepted")
+	}
+	peer := conn.(*udpConn)
+	peer.ReadMessage()
+
+	for i := 0; i < udpAcceptBacklog+10; i++ {
+		c := dialTestUDP(t, l)
+		defer c.Close()
+		c.Write([]byte("new"))
+	}
+	first.Write([]byte("again"))
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if val, err := peer.ReadMessage(); err != nil || string(val) != "again" {
+		t.Fatalf("expected again, got %q %v", val, err)
+	}
+}
+
+// 可靠udp关闭之后，对端迟到的重传不会建立新的连接
+func TestUDPListenerClosedPeer(t *testing.T) {
+	option := &ARQOption{RetransmitInterval: 20 * time.Millisecond, RetransmitTimes: 2, Window: 4}
+	l := newTestUDPListener(t, option)
+	defer l.Close()
+	client := dialTestUDP(t, l)
+	defer client.Close()
+
+	data := make([]byte, arqHeadSize+4)
+	data[0] = arqCmdData
+	libio.PutUint32BE(data[1:arqHeadSize], 0)
+	client.Write(data)
+	conn := acceptTimeout(l, time.Second)
+	if conn == nil {
+		t.Fatal("peer not accepted")
+	}
+	conn.Close()
+
+	client.Write(data)
+	if conn = acceptTimeout(l, 20*time.Millisecond); conn != nil {
+		t.Fatal("late retransmit created a new peer")
+	}
+	// 超过重传的时间之后，同一个地址可以重新建立连接
+	time.Sleep(option.RetransmitInterval * time.Duration(option.RetransmitTimes+1))
+	client.Write(data)
+	if conn = acceptTimeout(l, time.Second); conn == nil {
+		t.Fatal("peer not accepted after linger")
+	}
+	conn.Close()
+}
+
+func TestUDPDialConnReadMessage(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newUDPDialConn(conn)
+	defer c.Close()
+	c.WriteMessage([]byte("hi"))
+	buf := make([]byte, 16)
+	_, addr, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.WriteTo([]byte("first"), addr)
+	pc.WriteTo([]byte("second"), addr)
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	first, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 读取的缓冲复用，返回的包按实际的长度复制，不会被后面的包覆盖
+	if string(first) != "first" || string(second) != "second" || cap(first) != 5 || cap(second) != 6 {
+		t.Fatalf("unexpected messages %q/%d %q/%d", first, cap(first), second, cap(second))
+	}
+}
@@ -0,0 +1,296 @@
+package libnet2
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wuqifei/server_lib/libio"
+)
+
+// websocket的网络类型，NetOption.Network 和 ClientOption.Network 使用
+// 服务端的地址和tcp一样，客户端的地址为 ws://host:port/path 或者 wss://host:port/path
+const NetworkWebSocket = "ws"
+
+const (
+	// websocket关闭帧的等待时间
+	wsCloseTimeout = time.Second
+	// 升级之前等待服务 Accept 的时间，达到 MaxConn 的时候等不到，回复503
+	wsAcceptWait = 200 * time.Millisecond
+)
+
+// 按消息读写的连接，websocket的连接实现了这个接口
+// MessagePacket 使用，一条消息就是一个包
+type MessageConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(b []byte) error
+}
+
+// websocket的监听，http升级之后的连接从Accept返回，后面的流程和tcp完全一样
+// 有 Accept 在等待的时候才会升级，超过 MaxConn 的请求直接拒绝，不会堆积升级的协程
+type wsListener struct {
+	listener net.Listener
+	server   *http.Server
+	upgrader *websocket.Upgrader
+
+	// Accept 在等待的时候可以取到一个
+	readyChan chan bool
+	// 升级之后的连接，升级失败的时候为nil，Accept 重新等待
+	connChan  chan net.Conn
+	closeOnce sync.Once
+	closeChan chan bool
+}
+
+func newWSListener(listener net.Listener, option *NetOption) *wsListener {
+	l := new(wsListener)
+	l.listener = listener
+	l.readyChan = make(chan bool)
+	l.connChan = make(chan net.Conn)
+	l.closeChan = make(chan bool)
+	l.upgrader = &websocket.Upgrader{
+		HandshakeTimeout: option.WebSocketHandshakeTimeout,
+		CheckOrigin:      option.WebSocketCheckOrigin,
+	}
+	if l.upgrader.CheckOrigin == nil {
+		// 小程序和app没有origin，浏览器跨域由使用者自己决定
+		l.upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+	}
+
+	path := option.WebSocketPath
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, l.upgrade)
+	l.server = &http.Server{Handler: mux, ReadHeaderTimeout: option.WebSocketHandshakeTimeout}
+	go l.server.Serve(listener)
+	return l
+}
+
+func (l *wsListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	timer := time.NewTimer(wsAcceptWait)
+	defer timer.Stop()
+	select {
+	case <-l.readyChan:
+	case <-timer.C:
+		http.Error(w, ErrConnLimit.Error(), http.StatusServiceUnavailable)
+		return
+	case <-l.closeChan:
+		http.Error(w, errClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// 升级失败的时候已经回复了http错误，交给 Accept 一个nil让它重新等待
+	var conn net.Conn
+	if ws, err := l.upgrader.Upgrade(w, r, nil); err == nil {
+		conn = newWSConn(ws)
+	}
+	select {
+	case l.connChan <- conn:
+	case <-l.closeChan:
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	for {
+		select {
+		case l.readyChan <- true:
+		case <-l.closeChan:
+			return nil, errClosed
+		}
+		select {
+		case conn := <-l.connChan:
+			if conn != nil {
+				return conn, nil
+			}
+		case <-l.closeChan:
+			return nil, errClosed
+		}
+	}
+}
+
+func (l *wsListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+		// 已经升级的连接被http劫持了，不受影响
+		err = l.server.Close()
+	})
+	return err
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// websocket的连接
+// 按流读写的时候，多条消息连在一起读，每次Write是一条二进制消息
+// 按消息读写的时候，使用 MessagePacket
+type wsConn struct {
+	ws *websocket.Conn
+	// 一条消息最多的字节数，超过的时候读取返回 *FrameSizeError
+	limit int64
+	// 当前正在读的消息
+	reader io.Reader
+	// websocket只允许一个协程写
+	writeMutex sync.Mutex
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	c := new(wsConn)
+	c.ws = ws
+	c.setReadLimit(DefaultMaxFrameSize + libio.MaxVarintLen64)
+	return c
+}
+
+// 新建session的时候按照包的最大长度设置
+func (c *wsConn) setReadLimit(limit int64) {
+	c.limit = limit
+	c.ws.SetReadLimit(limit)
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, reader, err := c.ws.NextReader()
+			if err != nil {
+				return 0, c.error(err)
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			// 当前消息读完了，继续读下一条
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if err != nil {
+			err = c.error(err)
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.WriteMessage(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, val, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, c.error(err)
+	}
+	return val, nil
+}
+
+func (c *wsConn) WriteMessage(b []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.ws.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// 先发关闭帧，再关闭连接
+func (c *wsConn) Close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsCloseTimeout))
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+// 对端关闭的时候，和tcp一样返回io.EOF
+// 消息太大的时候返回 *FrameSizeError，session会被关闭
+func (c *wsConn) error(err error) error {
+	if _, ok := err.(*websocket.CloseError); ok {
+		return io.EOF
+	}
+	if err == websocket.ErrReadLimit {
+		return &FrameSizeError{Size: c.limit + 1, Max: int(c.limit)}
+	}
+	return err
+}
+
+// 按消息读写的策略，一条websocket消息就是一个包
+// 只能用在实现了 MessageConn 的连接上
+type MessagePacket struct {
+	maxSize int
+}
+
+// maxSize 为一条消息最大的长度，小于等于0的时候使用 DefaultMaxFrameSize
+func NewMessagePacket(maxSize int) *MessagePacket {
+	p := new(MessagePacket)
+	p.maxSize = maxSize
+	if p.maxSize <= 0 {
+		p.maxSize = DefaultMaxFrameSize
+	}
+	return p
+}
+
+func (p *MessagePacket) MaxFrameSize() int {
+	return p.maxSize
+}
+
+func (p *MessagePacket) Read(r *libio.Reader) ([]byte, error) {
+	conn, ok := r.R.(MessageConn)
+	if !ok {
+		return nil, ErrNotMessageConn
+	}
+	return conn.ReadMessage()
+}
+
+func (p *MessagePacket) Write(w *libio.Writer, b []byte) error {
+	conn, ok := w.W.(MessageConn)
+	if !ok {
+		return ErrNotMessageConn
+	}
+	if len(b) > p.maxSize {
+		return &FrameSizeError{Size: int64(len(b)), Max: p.maxSize}
+	}
+	return conn.WriteMessage(b)
+}
+
+// 客户端连接websocket服务
+func dialWebSocket(option *ClientOption) (net.Conn, error) {
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: option.DialTimeout,
+		TLSClientConfig:  option.TLSConfig,
+	}
+	ws, _, err := dialer.Dial(option.Address, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newWSConn(ws), nil
+}
@@ -0,0 +1,141 @@
+package libnet2
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 启动websocket的回显服务，返回服务和客户端连接的地址
+func newWSEchoServer(t *testing.T, option *NetOption, packet PacketInterface, errChan chan error) (LibserverInterface, string) {
+	option.Network = NetworkWebSocket
+	option.Address = "127.0.0.1:0"
+	option.WebSocketPath = "/ws"
+	handler := NewHandler(packet)
+	handler.OnRecv = func(sess Session2Interface, val []byte) {
+		sess.Send(append([]byte(nil), val...))
+	}
+	handler.OnSessError = func(sess Session2Interface, err error) {
+		errChan <- err
+	}
+	server, err := NewWithOption(option, DefaultSessionOption(), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Run()
+	scheme := "ws://"
+	if option.TLSConfig != nil {
+		scheme = "wss://"
+	}
+	return server, scheme + server.Listener().Addr().String() + "/ws"
+}
+
+// 客户端发送之后等待回显
+func wsEcho(t *testing.T, address string, config *tls.Config, packet PacketInterface) {
+	option := DefaultClientOption()
+	option.Network = NetworkWebSocket
+	option.Address = address
+	option.TLSConfig = config
+	option.Reconnect = false
+	recvChan := make(chan []byte, 1)
+	handler := NewHandler(packet)
+	handler.OnRecv = func(sess Session2Interface, val []byte) {
+		recvChan <- append([]byte(nil), val...)
+	}
+	client, err := Dial(option, DefaultSessionOption(), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	msg := bytes.Repeat([]byte("ws"), 100)
+	if err = client.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case val := <-recvChan:
+		if !bytes.Equal(val, msg) {
+			t.Fatalf("expected echo, got %q", val)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("echo not received")
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	errChan := make(chan error, 4)
+	packet := NewLengthPacket(2, BigEndian, 1024)
+	server, address := newWSEchoServer(t, DefaultOption(), packet, errChan)
+	defer server.Close()
+	wsEcho(t, address, nil, packet)
+}
+
+func TestWebSocketTLSEcho(t *testing.T) {
+	ca := newTestCert(t, "ca", 1, nil)
+	cert := newTestCert(t, "server", 2, ca)
+	option := DefaultOption()
+	option.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert.tlsCert()}}
+	errChan := make(chan error, 4)
+	packet := NewMessagePacket(1024)
+	server, address := newWSEchoServer(t, option, packet, errChan)
+	defer server.Close()
+	if !strings.HasPrefix(address, "wss://") {
+		t.Fatalf("expected wss address, got %s", address)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	wsEcho(t, address, &tls.Config{RootCAs: pool}, packet)
+}
+
+func TestWebSocketReadLimit(t *testing.T) {
+	errChan := make(chan error, 4)
+	server, address := newWSEchoServer(t, DefaultOption(), NewMessagePacket(16), errChan)
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// 超过包的最大长度的消息不会被读进来，session被关闭
+	if err = ws.WriteMessage(websocket.BinaryMessage, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errChan:
+		if _, ok := err.(*FrameSizeError); !ok {
+			t.Fatalf("expected frame size error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("oversized message not rejected")
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err = ws.ReadMessage(); err == nil {
+		t.Fatal("expected connection closed")
+	}
+}
+
+func TestWebSocketMaxConn(t *testing.T) {
+	option := DefaultOption()
+	option.MaxConn = 1
+	errChan := make(chan error, 4)
+	server, address := newWSEchoServer(t, option, NewMessagePacket(1024), errChan)
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// 名额用完之后，升级直接被拒绝
+	_, resp, err := websocket.DefaultDialer.Dial(address, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %v %v", resp, err)
+	}
+}
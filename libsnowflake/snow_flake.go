@@ -6,6 +6,8 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"github.com/wuqifei/server_lib/libtime"
 )
 
 // 雪花算法的id部分, 总共64位
@@ -26,11 +28,14 @@ type Setting struct {
 	MachineID func() (uint16, error)
 	// 检查机器码,如果是false ,则不会创建snowflake
 	CheckMachineID func(uint16) bool
+	// 时钟,为nil的时候使用系统时间,测试中可以用 libtime.FakeClock
+	Clock libtime.Clock
 }
 
 // SnowFlake 雪花算法的主类
 type SnowFlake struct {
 	mutex       *sync.Mutex
+	clock       libtime.Clock
 	startTime   int64
 	elapsedTime int64
 	sequence    uint16
@@ -43,13 +48,17 @@ func New(st Setting) *SnowFlake {
 	sf.mutex = new(sync.Mutex)
 	// 变成最高序列
 	sf.sequence = uint16(1<<BitLenSequence - 1)
-	if st.StartTime.After(time.Now()) {
+	sf.clock = st.Clock
+	if sf.clock == nil {
+		sf.clock = libtime.RealClock
+	}
+	if st.StartTime.After(sf.clock.Now()) {
 		return nil
 	}
 
 	if st.StartTime.IsZero() {
 		// 取当前的utc时间
-		sf.startTime = toSnowFlakeTime(time.Now().UTC())
+		sf.startTime = toSnowFlakeTime(sf.clock.Now().UTC())
 	} else {
 		sf.startTime = toSnowFlakeTime(st.StartTime)
 	}
@@ -72,7 +81,7 @@ func (sf *SnowFlake) NextID() (uint64, error) {
 	const maskSequence = uint16(1<<BitLenSequence - 1)
 	sf.mutex.Lock()
 	defer sf.mutex.Unlock()
-	current := currentElapsedTime(sf.startTime)
+	current := sf.currentElapsedTime()
 	if sf.elapsedTime < current {
 		// 重置时间
 		sf.elapsedTime = current
@@ -83,7 +92,7 @@ func (sf *SnowFlake) NextID() (uint64, error) {
 		if sf.sequence == 0 {
 			sf.elapsedTime++
 			overtime := sf.elapsedTime - current
-			time.Sleep(sleepTime(overtime))
+			sf.clock.Sleep(sf.sleepTime(overtime))
 		}
 	}
 	return sf.toID()
@@ -99,8 +108,8 @@ func (sf *SnowFlake) toID() (uint64, error) {
 		uint64(sf.machineID), nil
 }
 
-func sleepTime(overtime int64) time.Duration {
-	return time.Duration(overtime)*10*time.Millisecond - time.Duration(time.Now().UTC().UnixNano()%snowflakeTimeUnit)*time.Nanosecond
+func (sf *SnowFlake) sleepTime(overtime int64) time.Duration {
+	return time.Duration(overtime)*10*time.Millisecond - time.Duration(sf.clock.Now().UTC().UnixNano()%snowflakeTimeUnit)*time.Nanosecond
 }
 
 const snowflakeTimeUnit = 1e7
@@ -111,8 +120,8 @@ func toSnowFlakeTime(t time.Time) int64 {
 }
 
 // 当前已经运行的时间
-func currentElapsedTime(startTime int64) int64 {
-	return toSnowFlakeTime(time.Now().UTC()) - startTime
+func (sf *SnowFlake) currentElapsedTime() int64 {
+	return toSnowFlakeTime(sf.clock.Now().UTC()) - sf.startTime
 }
 
 // 取本机的ip
@@ -0,0 +1,32 @@
+package libsnowflake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wuqifei/server_lib/libtime"
+)
+
+func TestNextIDWithFakeClock(t *testing.T) {
+	clock := libtime.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	sf := New(Setting{
+		MachineID: func() (uint16, error) { return 1, nil },
+		Clock:     clock,
+	})
+
+	// 开始的时候序号是满的，第一个id就要等到下一个10ms，之后每256个等一次
+	var last uint64
+	for i := 0; i < 1<<BitLenSequence+1; i++ {
+		id, err := sf.NextID()
+		if err != nil || id <= last {
+			t.Fatalf("id %d not increasing after %d: %v", id, last, err)
+		}
+		last = id
+	}
+	if elapsed := last >> (BitLenSequence + BitLenMachineID); elapsed != 2 {
+		t.Fatalf("expected elapsed 2, got %d", elapsed)
+	}
+	if now := clock.Now(); !now.Equal(time.Date(2024, 1, 1, 0, 0, 0, int(20*time.Millisecond), time.UTC)) {
+		t.Fatalf("expected clock moved by sleep, got %v", now)
+	}
+}
@@ -0,0 +1,135 @@
+package libtime
+
+import (
+	"sync"
+	"time"
+)
+
+// 时钟，测试的时候可以换成 FakeClock，不需要真的等待
+type Clock interface {
+	// 当前时间
+	Now() time.Time
+	// 等待一段时间
+	Sleep(d time.Duration)
+	// d 之后调用 fn，和 time.AfterFunc 一样
+	AfterFunc(d time.Duration, fn func()) ClockTimer
+}
+
+// AfterFunc 返回的定时器，*time.Timer 也满足
+type ClockTimer interface {
+	// 停止，已经触发或者已经停止的时候返回false
+	Stop() bool
+}
+
+// 系统的时钟
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, fn func()) ClockTimer {
+	return time.AfterFunc(d, fn)
+}
+
+// 手动拨动的时钟，Advance 的时候在调用的协程中按时间顺序执行到期的 AfterFunc
+// Advance 返回的时候，这段时间内的回调都已经执行完
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// 同时到期的按添加的顺序执行
+	seq int64
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	seq   int64
+	fn    func()
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.now = now
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// 不会阻塞，直接把时间往前拨，到期的回调会在这里执行
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, fn func()) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	t := &fakeTimer{clock: c, when: c.now.Add(d), seq: c.seq, fn: fn}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 把时间往前拨 d，到期的回调按时间顺序执行，执行的时候 Now 返回回调到期的时间
+// 回调中新加的 AfterFunc 在这段时间内到期的也会执行
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		index := -1
+		for i, t := range c.timers {
+			if t.when.After(target) {
+				continue
+			}
+			if next == nil || t.when.Before(next.when) || (t.when.Equal(next.when) && t.seq < next.seq) {
+				next, index = t, i
+			}
+		}
+		if next == nil {
+			break
+		}
+		c.timers = append(c.timers[:index], c.timers[index+1:]...)
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		c.mutex.Unlock()
+		next.fn()
+		c.mutex.Lock()
+	}
+	if target.After(c.now) {
+		c.now = target
+	}
+	c.mutex.Unlock()
+}
+
+// 还没到期的 AfterFunc 个数
+func (c *FakeClock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
@@ -0,0 +1,70 @@
+package libtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockWheel(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC))
+	option := DefaultTimerWheelOption()
+	// 拨动两天，一秒一格就够了
+	option.Tick = time.Second
+	option.Workers = 0
+	option.Clock = clock
+	w := NewTimerWheelWithOption(option)
+	defer w.Stop()
+
+	var fired []string
+	record := func(val interface{}) {
+		fired = append(fired, val.(string))
+	}
+	w.AddTask(30*time.Second, 1, NewTimerTaskTimeOut("30s", record))
+	w.AddTask(90*time.Second, 1, NewTimerTaskTimeOut("90s", record))
+	w.AddTask(20*time.Second, 3, NewTimerTaskTimeOut("every 20s", record))
+
+	clock.Advance(time.Minute)
+	if len(fired) != 4 || fired[0] != "every 20s" || fired[1] != "30s" {
+		t.Fatalf("unexpected fired %v", fired)
+	}
+	fired = nil
+	clock.Advance(time.Minute)
+	if len(fired) != 1 || fired[0] != "90s" {
+		t.Fatalf("unexpected fired %v", fired)
+	}
+
+	// 计划任务也使用时间轮的时钟
+	c := NewCron(w, time.UTC)
+	runs := 0
+	id, _ := c.AddJob("@daily", func() error {
+		runs++
+		return nil
+	})
+	if e, _ := c.Entry(id); !e.Next.Equal(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected next %v", e.Next)
+	}
+	clock.Advance(48 * time.Hour)
+	if e, _ := c.Entry(id); runs != 2 || !e.Next.Equal(time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected runs %d next %v", runs, e.Next)
+	}
+	c.Stop()
+}
+
+func TestFakeClockInterval(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	option := DefaultTimerWheelOption()
+	option.Workers = 0
+	option.Clock = clock
+	w := NewTimerWheelWithOption(option)
+	defer w.Stop()
+
+	// 间隔不是tick的整数倍，平均下来还是按间隔触发
+	fired := 0
+	w.AddTask(option.Tick*3/2, -1, NewTimerTaskTimeOut(nil, func(interface{}) {
+		fired++
+	}))
+	clock.Advance(option.Tick * 150)
+	if fired != 100 {
+		t.Fatalf("expected 100 fires, got %d", fired)
+	}
+}
@@ -0,0 +1,190 @@
+package libtime
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wuqifei/server_lib/concurrent"
+)
+
+// 计划任务，返回的错误和panic记录在 CronEntry.LastError 中
+type CronJob func() error
+
+// 计划任务的状态
+type CronEntry struct {
+	ID   int64
+	Spec string
+	// 下一次执行的时间，没有下一次的时候为零值
+	Next time.Time
+	// 上一次执行的时间
+	Prev time.Time
+	// 执行的次数
+	Runs int64
+	// 上一次执行返回的错误
+	LastError error
+}
+
+type cronEntry struct {
+	CronEntry
+	schedule CronSchedule
+	job      CronJob
+	// 时间轮中的定时器
+	timerID int64
+}
+
+// 计划任务的调度，每个任务在时间轮上放一个到下次执行时间的定时器，执行之后再放下一个
+type Cron struct {
+	wheel    *TimerWheel
+	ownWheel bool
+	location *time.Location
+	ids      *concurrent.AtomicInt64
+
+	mutex    sync.Mutex
+	entries  map[int64]*cronEntry
+	stopFlag bool
+}
+
+// 新建调度，wheel 为nil的时候新建一个，Stop 的时候一起停止
+// location 是表达式没有指定时区时使用的时区，为nil的时候使用本地时区
+func NewCron(wheel *TimerWheel, location *time.Location) *Cron {
+	c := &Cron{}
+	if wheel == nil {
+		wheel = NewTimerWheel()
+		c.ownWheel = true
+	}
+	if location == nil {
+		location = time.Local
+	}
+	c.wheel = wheel
+	c.location = location
+	c.ids = concurrent.NewAtomicInt64(0)
+	c.entries = make(map[int64]*cronEntry)
+	return c
+}
+
+// 添加任务，返回任务的id，表达式的格式见 ParseCron
+func (c *Cron) AddJob(spec string, job CronJob) (int64, error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return -1, err
+	}
+	return c.AddSchedule(spec, schedule, job), nil
+}
+
+// 用自己实现的时间表添加任务，spec 只用来展示
+func (c *Cron) AddSchedule(spec string, schedule CronSchedule, job CronJob) int64 {
+	e := &cronEntry{schedule: schedule, job: job}
+	e.ID = c.ids.IncrementAndGet()
+	e.Spec = spec
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopFlag {
+		return -1
+	}
+	c.entries[e.ID] = e
+	c.schedule(e, c.wheel.clock.Now())
+	return e.ID
+}
+
+// 删除任务，正在执行的不会被打断
+func (c *Cron) RemoveJob(id int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.entries[id]; ok {
+		c.wheel.CancelTimer(e.timerID)
+		delete(c.entries, id)
+	}
+}
+
+// 任务的状态
+func (c *Cron) Entry(id int64) (CronEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.entries[id]; ok {
+		return e.CronEntry, true
+	}
+	return CronEntry{}, false
+}
+
+// 所有任务的状态，按下一次执行的时间排序，没有下一次的排在最后
+func (c *Cron) Entries() []CronEntry {
+	c.mutex.Lock()
+	entries := make([]CronEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e.CronEntry)
+	}
+	c.mutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Next.IsZero() != entries[j].Next.IsZero() {
+			return entries[j].Next.IsZero()
+		}
+		if !entries[i].Next.Equal(entries[j].Next) {
+			return entries[i].Next.Before(entries[j].Next)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// 停止调度，正在执行的任务不会被打断
+func (c *Cron) Stop() {
+	c.mutex.Lock()
+	c.stopFlag = true
+	for id, e := range c.entries {
+		c.wheel.CancelTimer(e.timerID)
+		delete(c.entries, id)
+	}
+	c.mutex.Unlock()
+
+	if c.ownWheel {
+		c.wheel.Stop()
+	}
+}
+
+// 放下一次执行的定时器，需要持有锁
+// 从上一次计划的时间往后算，定时器晚触发的误差不会累积
+func (c *Cron) schedule(e *cronEntry, now time.Time) {
+	from := now
+	if !e.Next.IsZero() {
+		from = e.Next
+	}
+	next := e.schedule.Next(from.In(c.location))
+	if !next.IsZero() && next.Before(now) {
+		// 执行得比间隔还久，跳过已经错过的时间
+		next = e.schedule.Next(now.In(c.location))
+	}
+	e.Next = next
+	if e.Next.IsZero() {
+		e.timerID = -1
+		return
+	}
+	e.timerID = c.wheel.AddTask(e.Next.Sub(now), 1, NewTimerTaskTimeOut(e.ID, c.run))
+}
+
+func (c *Cron) run(val interface{}) {
+	id := val.(int64)
+	c.mutex.Lock()
+	e, ok := c.entries[id]
+	c.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	start := c.wheel.clock.Now()
+	// panic记录成 *PanicError
+	err := safeCall(e.job)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entries[id] != e {
+		// 执行的时候被删除了
+		return
+	}
+	e.Prev = start
+	e.Runs++
+	e.LastError = err
+	c.schedule(e, c.wheel.clock.Now())
+}
@@ -0,0 +1,293 @@
+package libtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 计划任务的时间表
+type CronSchedule interface {
+	// t 之后下一次执行的时间，没有下一次的时候返回零值
+	Next(t time.Time) time.Time
+}
+
+// 字段的范围
+type cronBounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	cronSeconds = cronBounds{0, 59, nil}
+	cronMinutes = cronBounds{0, 59, nil}
+	cronHours   = cronBounds{0, 23, nil}
+	cronDom     = cronBounds{1, 31, nil}
+	cronMonths  = cronBounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7也是星期天
+	cronDow = cronBounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// 字段是 * 或者 ? 的时候设置的位，日期和星期的匹配要用到
+const cronStar = 1 << 63
+
+// 标准的cron表达式，每个字段是一个位图
+type cronSpec struct {
+	second, minute, hour, dom, month, dow uint64
+	// 为nil的时候使用传进来的时间的时区
+	location *time.Location
+}
+
+// 固定间隔的时间表
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s *everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// 解析cron表达式
+// 支持5个字段(分 时 日 月 星期)和6个字段(秒 分 时 日 月 星期)
+// 支持 @yearly @annually @monthly @weekly @daily @midnight @hourly 和 @every 5m
+// 可以用 CRON_TZ=Asia/Shanghai 或者 TZ=Asia/Shanghai 开头指定时区
+func ParseCron(spec string) (CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	var location *time.Location
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("libtime: cron spec %q has no fields", spec)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("libtime: cron spec %q: %v", spec, err)
+		}
+		location = loc
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		return parseCronDescriptor(spec, location)
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("libtime: cron spec %q needs 5 or 6 fields, got %d", spec, len(fields))
+	}
+
+	s := &cronSpec{location: location}
+	targets := []*uint64{&s.second, &s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	bounds := []cronBounds{cronSeconds, cronMinutes, cronHours, cronDom, cronMonths, cronDow}
+	for i, field := range fields {
+		bits, err := parseCronField(field, bounds[i])
+		if err != nil {
+			return nil, fmt.Errorf("libtime: cron spec %q: %v", spec, err)
+		}
+		*targets[i] = bits
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseCronDescriptor(spec string, location *time.Location) (CronSchedule, error) {
+	all := func(b cronBounds) uint64 {
+		return cronRange(b.min, b.max, 1) | cronStar
+	}
+	s := &cronSpec{location: location}
+	switch spec {
+	case "@yearly", "@annually":
+		s.second, s.minute, s.hour, s.dom, s.month, s.dow = 1, 1, 1, 1<<1, 1<<1, all(cronDow)
+	case "@monthly":
+		s.second, s.minute, s.hour, s.dom, s.month, s.dow = 1, 1, 1, 1<<1, all(cronMonths), all(cronDow)
+	case "@weekly":
+		s.second, s.minute, s.hour, s.dom, s.month, s.dow = 1, 1, 1, all(cronDom), all(cronMonths), 1
+	case "@daily", "@midnight":
+		s.second, s.minute, s.hour, s.dom, s.month, s.dow = 1, 1, 1, all(cronDom), all(cronMonths), all(cronDow)
+	case "@hourly":
+		s.second, s.minute, s.hour, s.dom, s.month, s.dow = 1, 1, all(cronHours), all(cronDom), all(cronMonths), all(cronDow)
+	default:
+		if strings.HasPrefix(spec, "@every ") {
+			interval, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+			if err != nil {
+				return nil, fmt.Errorf("libtime: cron spec %q: %v", spec, err)
+			}
+			if interval < MIN_TIMER_INTERVAL {
+				return nil, fmt.Errorf("libtime: cron spec %q: interval too small", spec)
+			}
+			return &everySchedule{interval: interval}, nil
+		}
+		return nil, fmt.Errorf("libtime: unknown cron descriptor %q", spec)
+	}
+	return s, nil
+}
+
+// 解析一个字段，逗号分隔的 * ? a a-b 以及后面跟着的 /step
+func parseCronField(field string, b cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, uint(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.ParseUint(part[i+1:], 10, 32)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rangePart, step = part[:i], uint(n)
+		}
+
+		var start, end uint
+		var extra uint64
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			start, end = b.min, b.max
+			if step == 1 {
+				extra = cronStar
+			}
+		case strings.Contains(rangePart, "-"):
+			i := strings.Index(rangePart, "-")
+			var err error
+			if start, err = parseCronValue(rangePart[:i], b); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(rangePart[i+1:], b); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if start, err = parseCronValue(rangePart, b); err != nil {
+				return 0, err
+			}
+			end = start
+			if step > 1 {
+				// a/step 表示从a到最大值
+				end = b.max
+			}
+		}
+		if start > end {
+			return 0, fmt.Errorf("bad range in %q", part)
+		}
+		bits |= cronRange(start, end, step) | extra
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, b cronBounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	if uint(n) < b.min || uint(n) > b.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", n, b.min, b.max)
+	}
+	return uint(n), nil
+}
+
+func cronRange(start, end, step uint) uint64 {
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << i
+	}
+	return bits
+}
+
+// 日期和星期都限定的时候满足一个就行，有一个是*的时候两个都要满足
+func (s *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.dom&cronStar != 0 || s.dow&cronStar != 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// 从大到小逐个字段找下一个匹配的时间，进位的时候重新从月份开始
+// 5年之内找不到的时候返回零值，例如2月30日
+func (s *cronSpec) Next(t time.Time) time.Time {
+	origin := t.Location()
+	if s.location != nil {
+		t = t.In(s.location)
+	}
+	loc := t.Location()
+
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	yearLimit := t.Year() + 5
+	added := false
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.second&(1<<uint(t.Second())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t.In(origin)
+}
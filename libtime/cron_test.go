@@ -0,0 +1,112 @@
+package libtime
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	cases := []struct {
+		spec string
+		from string
+		next string
+	}{
+		{"* * * * *", "2024-01-01 10:00:30", "2024-01-01 10:01:00"},
+		{"*/15 * * * * *", "2024-01-01 10:00:30", "2024-01-01 10:00:45"},
+		{"30 9 * * mon-fri", "2024-01-05 09:30:00", "2024-01-08 09:30:00"},
+		{"0 0 1,15 * *", "2024-01-02 00:00:00", "2024-01-15 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		// 日期和星期都限定的时候满足一个就行
+		{"0 0 13 * 5", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 12 * * 7", "2024-01-01 00:00:00", "2024-01-07 12:00:00"},
+		{"0 0 * jan-mar/2 *", "2024-02-10 00:00:00", "2024-03-01 00:00:00"},
+		{"@daily", "2024-12-31 23:59:59", "2025-01-01 00:00:00"},
+		{"@weekly", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"@monthly", "2024-01-31 10:00:00", "2024-02-01 00:00:00"},
+		{"@yearly", "2024-06-01 00:00:00", "2025-01-01 00:00:00"},
+		{"@hourly", "2024-01-01 10:59:59", "2024-01-01 11:00:00"},
+		{"@every 90s", "2024-01-01 10:00:00", "2024-01-01 10:01:30"},
+		{"0 0 30 2 *", "2024-01-01 00:00:00", ""},
+	}
+	for _, c := range cases {
+		schedule, err := ParseCron(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		from, _ := time.ParseInLocation("2006-01-02 15:04:05", c.from, shanghai)
+		next := schedule.Next(from)
+		got := ""
+		if !next.IsZero() {
+			got = next.Format("2006-01-02 15:04:05")
+		}
+		if got != c.next {
+			t.Fatalf("%s from %s: expected %s, got %s", c.spec, c.from, c.next, got)
+		}
+	}
+
+	// 表达式指定的时区，返回的时间还是原来的时区
+	schedule, _ := ParseCron("CRON_TZ=Asia/Shanghai 0 8 * * *")
+	next := schedule.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC); !next.Equal(want) || next.Location() != time.UTC {
+		t.Fatalf("expected %v, got %v", want, next)
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@often", "TZ=Nowhere/City * * * * *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Fatalf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestCronJobs(t *testing.T) {
+	c := NewCron(nil, nil)
+	defer c.Stop()
+
+	runs := make(chan bool, 10)
+	failed := errors.New("failed")
+	id, err := c.AddJob("@every 30ms", func() error {
+		runs <- true
+		return failed
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily, _ := c.AddJob("@daily", func() error { return nil })
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatal("job not run")
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	e, ok := c.Entry(id)
+	if !ok || e.Runs < 2 || e.LastError != failed || !e.Next.After(e.Prev) {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if entries := c.Entries(); len(entries) != 2 || entries[0].ID != id || entries[1].ID != daily {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	c.RemoveJob(id)
+	if _, ok := c.Entry(id); ok {
+		t.Fatal("job not removed")
+	}
+	time.Sleep(60 * time.Millisecond)
+	select {
+	case <-runs:
+		// 删除之前可能已经在执行了
+	default:
+	}
+	select {
+	case <-runs:
+		t.Fatal("removed job still running")
+	case <-time.After(60 * time.Millisecond):
+	}
+}
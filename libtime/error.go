@@ -0,0 +1,14 @@
+package libtime
+
+import "errors"
+
+var (
+	// 定时器的回调为空
+	ErrTimeOutNull = errors.New("timer timeout is nil")
+
+	// 时间轮已经停止
+	ErrWheelStopped = errors.New("timer wheel stopped")
+
+	// 定时器被取消
+	ErrTimerCanceled = errors.New("timer canceled")
+)
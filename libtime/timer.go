@@ -0,0 +1,134 @@
+package libtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wuqifei/server_lib/concurrent"
+)
+
+// AddTimer 返回的定时器句柄
+type Timer struct {
+	wheel *TimerWheel
+	task  *TimerTask
+	fired *concurrent.AtomicInt64
+
+	doneOnce sync.Once
+	done     chan struct{}
+	// 解除和 ctx 的绑定
+	stop func() bool
+	// Done 关闭之后才能读取
+	err error
+}
+
+func newTimer(wheel *TimerWheel, task *TimerTask) *Timer {
+	t := new(Timer)
+	t.wheel = wheel
+	t.task = task
+	t.fired = concurrent.NewAtomicInt64(0)
+	t.done = make(chan struct{})
+	return t
+}
+
+// 添加定时器，返回可以取消和重置的句柄
+// ctx 结束的时候自动取消，可以把定时器和请求或者session的生命周期绑定，不需要的时候传 context.Background()
+func (w *TimerWheel) AddTimer(ctx context.Context, interval time.Duration, count int64, to *TimerTaskTimeOut) (*Timer, error) {
+	if to == nil || count == 0 {
+		return nil, ErrTimeOutNull
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	task := newTask(w.clock.Now(), interval, count, to)
+	t := newTimer(w, task)
+	task.handle = t
+
+	// 不单独起协程，ctx 结束的时候才执行取消，定时器结束的时候解除
+	if ctx.Done() != nil {
+		t.stop = context.AfterFunc(ctx, func() {
+			t.cancel(ctx.Err())
+		})
+	}
+	if !w.add(task) {
+		t.finish(ErrWheelStopped)
+		return nil, ErrWheelStopped
+	}
+	// 加入之前 ctx 已经结束，上面的取消找不到任务
+	if err := ctx.Err(); err != nil {
+		t.cancel(err)
+	}
+	return t, nil
+}
+
+// 定时器的id，可以用于 TimerWheel.CancelTimer
+func (t *Timer) ID() int64 {
+	return t.task.id
+}
+
+// 已经触发的次数
+func (t *Timer) Fired() int64 {
+	return t.fired.Get()
+}
+
+// 定时器结束的时候关闭，所有的次数执行完，或者被取消
+// 最后一次触发的时候，等回调执行完才关闭
+func (t *Timer) Done() <-chan struct{} {
+	return t.done
+}
+
+// 结束的原因，Done 关闭之前和正常执行完的时候为nil
+// 取消的时候为 ErrTimerCanceled，ctx 结束的时候为 ctx.Err()
+func (t *Timer) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// 取消定时器，已经结束或者最后一次正在执行的时候返回false，正在执行的回调不会被打断
+func (t *Timer) Cancel() bool {
+	return t.cancel(ErrTimerCanceled)
+}
+
+func (t *Timer) cancel(err error) bool {
+	t.wheel.mutex.Lock()
+	defer t.wheel.mutex.Unlock()
+	return t.wheel.remove(t.task, err)
+}
+
+// 从现在开始 d 之后再触发，循环的定时器之后的间隔也改为 d，已经触发的次数不变
+// 已经结束的时候返回false
+func (t *Timer) Reset(d time.Duration) bool {
+	if d < MIN_TIMER_INTERVAL {
+		d = MIN_TIMER_INTERVAL
+	}
+	w := t.wheel
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	task := t.task
+	if w.tasks[task.id] != task {
+		return false
+	}
+	if task.slot != nil {
+		task.slot.remove(task)
+	}
+	task.interval = d
+	task.firetime = w.clock.Now().Add(d)
+	task.expire = w.ticks(task.firetime.Sub(w.start))
+	w.place(task)
+	return true
+}
+
+// 结束定时器，只会执行一次
+func (t *Timer) finish(err error) {
+	t.doneOnce.Do(func() {
+		if t.stop != nil {
+			t.stop()
+		}
+		t.err = err
+		close(t.done)
+	})
+}
@@ -0,0 +1,164 @@
+package libtime
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	bufferSize               = 1024
+	tickPeriod time.Duration = 500 * time.Millisecond
+)
+
+// 基于二叉堆的定时器，取消的时候需要遍历查找
+// 保留下来做对比，新代码请使用 TimerWheel
+type HeapWheel struct {
+	pool       *workerPool
+	timers     *TimerHeap
+	ticker     *time.Ticker
+	waitGroup  sync.WaitGroup
+	addChan    chan *TimerTask
+	cancelChan chan int64
+	stopchan   chan bool
+	sizeChan   chan int
+}
+
+func NewHeapWheel() *HeapWheel {
+	wheel := &HeapWheel{}
+	wheel.pool = newWorkerPool(DefaultTimerWheelOption().Workers, bufferSize)
+	wheel.timers = NewHeep()
+	wheel.ticker = time.NewTicker(tickPeriod)
+	wheel.addChan = make(chan *TimerTask, bufferSize)
+	wheel.cancelChan = make(chan int64, bufferSize)
+	wheel.sizeChan = make(chan int)
+	wheel.stopchan = make(chan bool)
+	heap.Init(wheel.timers)
+	go func() {
+		wheel.start()
+	}()
+	return wheel
+}
+
+func (w *HeapWheel) AddTask(interval time.Duration, count int64, to *TimerTaskTimeOut) int64 {
+	if to == nil {
+		return -1
+	}
+	task := NewTask(interval, count, to)
+	w.addChan <- task
+	w.waitGroup.Add(1)
+	return task.id
+}
+
+func (w *HeapWheel) Size() int {
+	return <-w.sizeChan
+}
+
+// 关闭timer，关闭时候，使用这个方法
+func (w *HeapWheel) CancelTimer(id int64) {
+	w.cancelChan <- id
+}
+
+func (w *HeapWheel) Stop() {
+	//执行，并清空所有task
+	w.stopchan <- true
+	//等待所有的都执行完毕
+	w.waitGroup.Wait()
+	w.ticker.Stop()
+	w.pool.close()
+	close(w.cancelChan)
+	close(w.addChan)
+	close(w.stopchan)
+	close(w.sizeChan)
+}
+
+func (w *HeapWheel) getLattestTimer() []*TimerTask {
+	expired := make([]*TimerTask, 0)
+	for w.timers.Len() > 0 {
+		task := heap.Pop(w.timers).(*TimerTask)
+		nextFireTime := task.firetime
+		elasped := time.Since(nextFireTime).Seconds()
+		if elasped > 1.0 {
+			fmt.Printf("libtime:timer exec error with 1 second not exec\n")
+		}
+		if elasped > 0.0 {
+			//时间未到
+			expired = append(expired, task)
+			continue
+		} else {
+			heap.Push(w.timers, task)
+			break
+		}
+	}
+	return expired
+}
+
+func (w *HeapWheel) Remove(id int64) {
+	if id <= 0 {
+		return
+	}
+	index := w.timers.GetIndexByID(id)
+	if index >= 0 {
+		heap.Remove(w.timers, index)
+	}
+	w.waitGroup.Done()
+}
+
+func (w *HeapWheel) Flush() {
+	for w.timers.Len() > 0 {
+		task := heap.Pop(w.timers).(*TimerTask)
+		//执行调用
+		task.timeout.Callback(task.timeout.Content)
+		w.waitGroup.Done()
+	}
+}
+
+func (w *HeapWheel) updateTimers(timers []*TimerTask) {
+	if timers == nil {
+		return
+	}
+
+	for _, t := range timers {
+		if t.count < 0 || t.alreadyExec < (t.count-1) {
+			t.firetime = t.firetime.Add(t.interval)
+			if time.Since(t.firetime).Seconds() >= 1.0 {
+				t.firetime = time.Now()
+			}
+			heap.Push(w.timers, t)
+		} else {
+			// 这里时真正删除
+			//在这里将计数器减去1
+			w.waitGroup.Done()
+		}
+	}
+}
+
+func (w *HeapWheel) start() {
+	for {
+		select {
+		case id := <-w.cancelChan:
+			//取消某个
+			w.Remove(id)
+		case w.sizeChan <- w.timers.Len():
+		case <-w.stopchan:
+			w.Flush() //flush 之后要直接return 破开循环
+			return
+		case task := <-w.addChan:
+			heap.Push(w.timers, task)
+		case <-w.ticker.C:
+			timers := w.getLattestTimer()
+			for _, t := range timers {
+				t.alreadyExec++
+				//执行一次，交给协程池，不能阻塞循环
+				to := t.timeout
+				w.pool.submit(func() {
+					if err := to.call(); err != nil {
+						fmt.Printf("libtime:timer callback error %v\n", err)
+					}
+				})
+			}
+			w.updateTimers(timers)
+		}
+	}
+}
@@ -14,15 +14,26 @@ type TimerTask struct {
 	alreadyExec int64             //已经执行次数
 	timeout     *TimerTaskTimeOut //超时
 	index       int               //在heap中的位置
+
+	expire uint64     //时间轮中触发的tick
+	slot   *timerSlot //时间轮中所在的格子
+	prev   *TimerTask //格子中的链表
+	next   *TimerTask
+	handle *Timer //AddTimer 返回的句柄
 }
 
 func NewTask(interval time.Duration, count int64, to *TimerTaskTimeOut) *TimerTask {
+	return newTask(time.Now(), interval, count, to)
+}
+
+// now 是时间轮时钟的当前时间
+func newTask(now time.Time, interval time.Duration, count int64, to *TimerTaskTimeOut) *TimerTask {
 	if interval < MIN_TIMER_INTERVAL {
 		interval = MIN_TIMER_INTERVAL
 	}
 	task := &TimerTask{}
 	task.id = timerIds.GetAndIncrement()
-	task.firetime = time.Now().Add(interval)
+	task.firetime = now.Add(interval)
 	task.interval = interval
 	task.count = count
 	task.alreadyExec = 0
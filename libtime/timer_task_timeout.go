@@ -3,6 +3,9 @@ package libtime
 type TimerTaskTimeOut struct {
 	Callback func(val interface{}) //回调
 	Content  interface{}           //希望带过去的参数
+
+	ErrCallback func(val interface{}) error      //返回错误的回调，设置之后代替 Callback
+	OnError     func(val interface{}, err error) //回调返回错误或者panic的时候调用，为nil的时候使用时间轮的 OnError
 }
 
 func NewTimerTaskTimeOut(content interface{}, callback func(val interface{})) *TimerTaskTimeOut {
@@ -11,3 +14,23 @@ func NewTimerTaskTimeOut(content interface{}, callback func(val interface{})) *T
 	t.Callback = callback
 	return t
 }
+
+// 回调可以返回错误，错误和panic都会交给 onError
+func NewTimerTaskTimeOutWithError(content interface{}, callback func(val interface{}) error, onError func(val interface{}, err error)) *TimerTaskTimeOut {
+	t := &TimerTaskTimeOut{}
+	t.Content = content
+	t.ErrCallback = callback
+	t.OnError = onError
+	return t
+}
+
+// 执行回调，panic转换成 *PanicError
+func (t *TimerTaskTimeOut) call() error {
+	return safeCall(func() error {
+		if t.ErrCallback != nil {
+			return t.ErrCallback(t.Content)
+		}
+		t.Callback(t.Content)
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package libtime
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTimerHandle(t *testing.T) {
+	option := DefaultTimerWheelOption()
+	option.Tick = time.Millisecond
+	w := NewTimerWheelWithOption(option)
+	defer w.Stop()
+	nop := NewTimerTaskTimeOut(nil, func(interface{}) {})
+
+	// 执行完所有的次数
+	timer, err := w.AddTimer(context.Background(), 2*time.Millisecond, 3, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-timer.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timer not done")
+	}
+	if timer.Fired() != 3 || timer.Err() != nil || timer.Cancel() {
+		t.Fatalf("unexpected timer fired %d err %v", timer.Fired(), timer.Err())
+	}
+
+	// 取消
+	timer, _ = w.AddTimer(context.Background(), time.Hour, 1, nop)
+	if !timer.Cancel() || timer.Err() != ErrTimerCanceled || timer.Reset(time.Millisecond) {
+		t.Fatalf("unexpected cancel err %v", timer.Err())
+	}
+	if w.Size() != 0 {
+		t.Fatalf("expected empty wheel, got %d", w.Size())
+	}
+
+	// 重置之后重新计时
+	start := time.Now()
+	timer, _ = w.AddTimer(context.Background(), 20*time.Millisecond, 1, nop)
+	time.Sleep(10 * time.Millisecond)
+	if !timer.Reset(40 * time.Millisecond) {
+		t.Fatal("reset failed")
+	}
+	<-timer.Done()
+	if cost := time.Since(start); cost < 50*time.Millisecond || timer.Fired() != 1 {
+		t.Fatalf("reset timer fired after %v, %d times", cost, timer.Fired())
+	}
+
+	// ctx 结束的时候自动取消
+	ctx, cancel := context.WithCancel(context.Background())
+	timer, _ = w.AddTimer(ctx, time.Hour, -1, nop)
+	cancel()
+	select {
+	case <-timer.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timer not canceled by context")
+	}
+	if timer.Err() != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", timer.Err())
+	}
+	if _, err = w.AddTimer(ctx, time.Hour, 1, nop); err != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestTimerContextNoGoroutine(t *testing.T) {
+	w := NewTimerWheel()
+	defer w.Stop()
+	nop := NewTimerTaskTimeOut(nil, func(interface{}) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 绑定 ctx 的定时器不额外占用协程
+	before := runtime.NumGoroutine()
+	timers := make([]*Timer, 0, 100)
+	for i := 0; i < 100; i++ {
+		timer, err := w.AddTimer(ctx, time.Hour, 1, nop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timers = append(timers, timer)
+	}
+	if n := runtime.NumGoroutine(); n > before+10 {
+		t.Fatalf("expected no goroutine per timer, %d -> %d", before, n)
+	}
+
+	// 先取消的定时器解除绑定，ctx 结束的时候不会再改结束的原因
+	timers[0].Cancel()
+	cancel()
+	for i, timer := range timers {
+		select {
+		case <-timer.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("timer %d not canceled by context", i)
+		}
+	}
+	if timers[0].Err() != ErrTimerCanceled || timers[1].Err() != context.Canceled {
+		t.Fatalf("unexpected err %v %v", timers[0].Err(), timers[1].Err())
+	}
+	if w.Size() != 0 {
+		t.Fatalf("expected empty wheel, got %d", w.Size())
+	}
+}
@@ -1,158 +1,394 @@
 package libtime
 
 import (
-	"container/heap"
 	"fmt"
 	"sync"
 	"time"
 )
 
-const (
-	bufferSize               = 1024
-	tickPeriod time.Duration = 500 * time.Millisecond
-)
+// 时间轮的配置
+type TimerWheelOption struct {
+	// 每一格的时间，也就是定时器的精度，定时器只会晚触发，不会早触发
+	Tick time.Duration
+	// 每一层的格数，会向上取到2的幂
+	WheelSize int
+	// 层数，超出最高层范围的定时器先放在最高层的最后，转到的时候重新计算
+	Levels int
+
+	// 执行回调的协程数，0的时候在时间轮的协程中执行，慢的回调会耽误所有的定时器
+	// 回调在多个协程中执行，循环的定时器上一次还没执行完的时候，下一次可能已经开始了
+	Workers int
+	// 等待执行的回调队列，满了之后时间轮会等待
+	QueueSize int
+	// 回调返回错误或者panic，并且定时器没有设置 OnError 的时候调用，为nil的时候打印出来
+	OnError func(id int64, err error)
+
+	// 时钟，为nil的时候使用 RealClock，测试中可以用 FakeClock 手动推进
+	Clock Clock
+}
+
+// 默认10ms一格，每层256格，5层，4个协程执行回调
+func DefaultTimerWheelOption() *TimerWheelOption {
+	option := new(TimerWheelOption)
+	option.Tick = 10 * time.Millisecond
+	option.WheelSize = 256
+	option.Levels = 5
+	option.Workers = 4
+	option.QueueSize = bufferSize
+	return option
+}
+
+// 格子中的定时器，双向链表，取消的时候直接摘除
+type timerSlot struct {
+	head *TimerTask
+}
+
+func (s *timerSlot) push(task *TimerTask) {
+	task.slot = s
+	task.prev = nil
+	task.next = s.head
+	if s.head != nil {
+		s.head.prev = task
+	}
+	s.head = task
+}
 
+func (s *timerSlot) remove(task *TimerTask) {
+	if task.prev != nil {
+		task.prev.next = task.next
+	} else {
+		s.head = task.next
+	}
+	if task.next != nil {
+		task.next.prev = task.prev
+	}
+	task.slot, task.prev, task.next = nil, nil, nil
+}
+
+// 取出格子中所有的定时器
+func (s *timerSlot) take() *TimerTask {
+	head := s.head
+	s.head = nil
+	return head
+}
+
+// 到期的定时器，last 表示最后一次执行
+type expiredTask struct {
+	task *TimerTask
+	last bool
+}
+
+// 分层的时间轮，添加和取消都是O(1)
+// 第0层每一格是一个tick，上面每一层的一格是下一层转一圈的时间
+// 下一层转完一圈的时候，把上一层对应格子中的定时器重新放到下面的层
 type TimerWheel struct {
-	timeOutChan chan *TimerTaskTimeOut
-	timers      *TimerHeap
-	ticker      *time.Ticker
-	waitGroup   sync.WaitGroup
-	addChan     chan *TimerTask
-	cancelChan  chan int64
-	stopchan    chan bool
-	sizeChan    chan int
+	option *TimerWheelOption
+	bits   uint
+	mask   uint64
+
+	mutex  sync.Mutex
+	levels [][]timerSlot
+	tasks  map[int64]*TimerTask
+	start  time.Time
+	// 已经处理过的tick
+	current  uint64
+	stopFlag bool
+	// 只在 onTick 中使用
+	expired []expiredTask
+	pool    *workerPool
+
+	clock Clock
+	// onTick 同时只有一个在执行
+	tickMutex sync.Mutex
+	tickTimer ClockTimer
+	stopOnce  sync.Once
 }
 
+// 使用默认的配置新建
 func NewTimerWheel() *TimerWheel {
-	wheel := &TimerWheel{}
-	wheel.timeOutChan = make(chan *TimerTaskTimeOut, bufferSize)
-	wheel.timers = NewHeep()
-	wheel.ticker = time.NewTicker(tickPeriod)
-	wheel.addChan = make(chan *TimerTask, bufferSize)
-	wheel.cancelChan = make(chan int64, bufferSize)
-	wheel.sizeChan = make(chan int)
-	heap.Init(wheel.timers)
-	go func() {
-		wheel.start()
-	}()
-	return wheel
+	return NewTimerWheelWithOption(DefaultTimerWheelOption())
+}
+
+func NewTimerWheelWithOption(option *TimerWheelOption) *TimerWheel {
+	w := &TimerWheel{}
+	w.option = option
+	if w.option.Tick <= 0 {
+		w.option.Tick = MIN_TIMER_INTERVAL
+	}
+	if w.option.Levels < 1 {
+		w.option.Levels = 1
+	}
+	for size := 2; size < w.option.WheelSize; size <<= 1 {
+		w.bits++
+	}
+	w.bits++
+	w.mask = 1<<w.bits - 1
+	w.levels = make([][]timerSlot, w.option.Levels)
+	for i := range w.levels {
+		w.levels[i] = make([]timerSlot, 1<<w.bits)
+	}
+	w.tasks = make(map[int64]*TimerTask)
+	w.pool = newWorkerPool(w.option.Workers, w.option.QueueSize)
+	w.clock = w.option.Clock
+	if w.clock == nil {
+		w.clock = RealClock
+	}
+	w.start = w.clock.Now()
+	w.tickTimer = w.clock.AfterFunc(w.option.Tick, w.onTick)
+	return w
 }
 
+// 添加定时器，interval 之后第一次触发，count 为执行的次数，小于0的时候一直执行
+// 返回定时器的id，to为nil或者已经停止的时候返回-1
 func (w *TimerWheel) AddTask(interval time.Duration, count int64, to *TimerTaskTimeOut) int64 {
-	if to == nil {
+	if to == nil || count == 0 {
+		return -1
+	}
+	task := newTask(w.clock.Now(), interval, count, to)
+	if !w.add(task) {
 		return -1
 	}
-	task := NewTask(interval, count, to)
-	w.addChan <- task
-	w.waitGroup.Add(1)
 	return task.id
 }
 
+// 放进时间轮，已经停止的时候返回false
+func (w *TimerWheel) add(task *TimerTask) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopFlag {
+		return false
+	}
+	task.expire = w.ticks(task.firetime.Sub(w.start))
+	w.tasks[task.id] = task
+	w.place(task)
+	return true
+}
+
+// 时间换算成tick，向上取整
+func (w *TimerWheel) ticks(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d + w.option.Tick - 1) / w.option.Tick)
+}
+
+// 按照离触发还有多少tick放到对应的层
+func (w *TimerWheel) place(task *TimerTask) {
+	if task.expire <= w.current {
+		// 已经过期的放到下一格
+		task.expire = w.current + 1
+	}
+	delta := task.expire - w.current
+	top := len(w.levels) - 1
+	for level := 0; level <= top; level++ {
+		shift := w.bits * uint(level)
+		if level == top || delta < uint64(1)<<(shift+w.bits) {
+			if level == top && shift+w.bits < 64 && delta >= uint64(1)<<(shift+w.bits) {
+				// 超出范围，先放到最高层的最后一格，转到的时候重新计算
+				w.levels[level][(w.current>>shift-1)&w.mask].push(task)
+				return
+			}
+			w.levels[level][(task.expire>>shift)&w.mask].push(task)
+			return
+		}
+	}
+}
+
+// 存活的定时器个数
 func (w *TimerWheel) Size() int {
-	return <-w.sizeChan
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.tasks)
 }
 
-// 关闭timer，关闭时候，使用这个方法
+// 取消定时器
 func (w *TimerWheel) CancelTimer(id int64) {
-	w.cancelChan <- id
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if task, ok := w.tasks[id]; ok {
+		w.remove(task, ErrTimerCanceled)
+	}
 }
 
-func (w *TimerWheel) Stop() {
-	//执行，并清空所有task
-	w.stopchan <- true
-	//等待所有的都执行完毕
-	w.waitGroup.Wait()
-	w.ticker.Stop()
-	close(w.timeOutChan)
-	close(w.cancelChan)
-	close(w.addChan)
-	close(w.stopchan)
-	close(w.sizeChan)
-}
-
-func (w *TimerWheel) getLattestTimer() []*TimerTask {
-	expired := make([]*TimerTask, 0)
-	for w.timers.Len() > 0 {
-		task := heap.Pop(w.timers).(*TimerTask)
-		nextFireTime := task.firetime
-		elasped := time.Since(nextFireTime).Seconds()
-		if elasped > 1.0 {
-			fmt.Printf("libtime:timer exec error with 1 second not exec\n")
+// 从时间轮中删除，需要持有锁，已经不在时间轮中的时候返回false
+func (w *TimerWheel) remove(task *TimerTask, err error) bool {
+	if w.tasks[task.id] != task {
+		return false
+	}
+	if task.slot != nil {
+		task.slot.remove(task)
+	}
+	delete(w.tasks, task.id)
+	if task.handle != nil {
+		task.handle.finish(err)
+	}
+	return true
+}
+
+// 同 CancelTimer
+func (w *TimerWheel) Remove(id int64) {
+	w.CancelTimer(id)
+}
+
+// 所有的定时器马上执行一次，然后全部删除
+func (w *TimerWheel) Flush() {
+	w.mutex.Lock()
+	tasks := make([]*TimerTask, 0, len(w.tasks))
+	for _, task := range w.tasks {
+		if task.slot != nil {
+			task.slot.remove(task)
 		}
-		if elasped > 0.0 {
-			//时间未到
-			expired = append(expired, task)
-			continue
-		} else {
-			heap.Push(w.timers, task)
-			break
+		if task.handle != nil {
+			task.handle.fired.IncrementAndGet()
 		}
+		tasks = append(tasks, task)
+	}
+	w.tasks = make(map[int64]*TimerTask)
+	w.mutex.Unlock()
+
+	for _, task := range tasks {
+		w.execute(task, true)
 	}
-	return expired
 }
 
-func (w *TimerWheel) Remove(id int64) {
-	if id <= 0 {
+// 停止时间轮，还没有触发的定时器会执行一次，等待所有的回调执行完之后返回
+func (w *TimerWheel) Stop() {
+	w.stopOnce.Do(func() {
+		w.mutex.Lock()
+		w.stopFlag = true
+		w.mutex.Unlock()
+
+		// 等待正在执行的 onTick
+		w.tickMutex.Lock()
+		w.tickTimer.Stop()
+		w.tickMutex.Unlock()
+
+		w.Flush()
+		w.pool.close()
+	})
+}
+
+// 每一格调用一次，处理完之后按照开始的时间对齐放下一次，误差不会累积
+func (w *TimerWheel) onTick() {
+	w.tickMutex.Lock()
+	defer w.tickMutex.Unlock()
+
+	w.advance(w.clock.Now())
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopFlag {
 		return
 	}
-	index := w.timers.GetIndexByID(id)
-	if index >= 0 {
-		heap.Remove(w.timers, index)
+	next := w.start.Add(time.Duration(w.current+1) * w.option.Tick)
+	delay := next.Sub(w.clock.Now())
+	if delay < 0 {
+		delay = 0
 	}
-	w.waitGroup.Done()
+	w.tickTimer = w.clock.AfterFunc(delay, w.onTick)
 }
 
-func (w *TimerWheel) Flush() {
-	for w.timers.Len() > 0 {
-		task := heap.Pop(w.timers).(*TimerTask)
-		//执行调用
-		task.timeout.Callback(task.timeout.Content)
-		w.waitGroup.Done()
+// 一格一格地走到now，协程被耽误的时候会补上落下的格子
+func (w *TimerWheel) advance(now time.Time) {
+	target := uint64(now.Sub(w.start) / w.option.Tick)
+	for {
+		w.mutex.Lock()
+		if w.current >= target || w.stopFlag {
+			w.mutex.Unlock()
+			return
+		}
+		w.current++
+		w.cascade()
+		w.collect()
+		w.mutex.Unlock()
+
+		for i, e := range w.expired {
+			w.execute(e.task, e.last)
+			w.expired[i] = expiredTask{}
+		}
+		w.expired = w.expired[:0]
 	}
 }
 
-func (w *TimerWheel) updateTimers(timers []*TimerTask) {
-	if timers == nil {
-		return
+// 下面的层转完一圈的时候，把上一层当前格子的定时器放下来
+func (w *TimerWheel) cascade() {
+	for level := 1; level < len(w.levels); level++ {
+		shift := w.bits * uint(level)
+		if (w.current>>(shift-w.bits))&w.mask != 0 {
+			return
+		}
+		slot := &w.levels[level][(w.current>>shift)&w.mask]
+		for task := slot.take(); task != nil; {
+			next := task.next
+			task.slot, task.prev, task.next = nil, nil, nil
+			if task.expire == w.current {
+				// 正好是这一格，马上就会取出
+				w.levels[0][w.current&w.mask].push(task)
+			} else {
+				w.place(task)
+			}
+			task = next
+		}
 	}
+}
 
-	for _, t := range timers {
-		if t.count < 0 || t.alreadyExec < (t.count-1) {
-			t.firetime = t.firetime.Add(t.interval)
-			if time.Since(t.firetime).Seconds() >= 1.0 {
-				t.firetime = time.Now()
-			}
-			heap.Push(w.timers, t)
+// 取出当前格子中到期的定时器，循环的定时器重新放回去
+func (w *TimerWheel) collect() {
+	slot := &w.levels[0][w.current&w.mask]
+	for task := slot.take(); task != nil; {
+		next := task.next
+		task.slot, task.prev, task.next = nil, nil, nil
+		if task.expire > w.current {
+			// 只有一层的时候，超出范围的定时器还没到
+			w.place(task)
+			task = next
+			continue
+		}
+		task.alreadyExec++
+		if task.handle != nil {
+			task.handle.fired.IncrementAndGet()
+		}
+		last := task.count >= 0 && task.alreadyExec >= task.count
+		w.expired = append(w.expired, expiredTask{task: task, last: last})
+		if last {
+			delete(w.tasks, task.id)
 		} else {
-			// 这里时真正删除
-			//在这里将计数器减去1
-			w.waitGroup.Done()
+			// 按触发的时间重新换算，间隔不是tick的整数倍的时候每次向上取整会越积越多
+			task.firetime = task.firetime.Add(task.interval)
+			task.expire = w.ticks(task.firetime.Sub(w.start))
+			w.place(task)
 		}
+		task = next
 	}
 }
 
-func (w *TimerWheel) start() {
-	for {
-		select {
-		case id := <-w.cancelChan:
-			//取消某个
-			w.Remove(id)
-		case w.sizeChan <- w.timers.Len():
-		case <-w.stopchan:
-			w.Flush() //flush 之后要直接return 破开循环
-			return
-		case task := <-w.addChan:
-			heap.Push(w.timers, task)
-		case timeoutTask := <-w.timeOutChan:
-			timeoutTask.Callback(timeoutTask.Content)
-		case <-w.ticker.C:
-			timers := w.getLattestTimer()
-			for _, t := range timers {
-				t.alreadyExec++
-				//执行一次
-				w.timeOutChan <- t.timeout
-			}
-			w.updateTimers(timers)
+// 把回调交给协程池，最后一次执行完之后结束句柄
+func (w *TimerWheel) execute(task *TimerTask, last bool) {
+	id, to, handle := task.id, task.timeout, task.handle
+	w.pool.submit(func() {
+		if err := to.call(); err != nil {
+			w.report(id, to, err)
+		}
+		if last && handle != nil {
+			handle.finish(nil)
 		}
+	})
+}
+
+// 报告回调的错误，定时器自己的 OnError 优先
+func (w *TimerWheel) report(id int64, to *TimerTaskTimeOut, err error) {
+	switch {
+	case to.OnError != nil:
+		safeCall(func() error {
+			to.OnError(to.Content, err)
+			return nil
+		})
+	case w.option.OnError != nil:
+		safeCall(func() error {
+			w.option.OnError(id, err)
+			return nil
+		})
+	default:
+		fmt.Printf("libtime:timer %d callback error %v\n", id, err)
 	}
 }
@@ -0,0 +1,218 @@
+package libtime
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// 一格一个小时，使用手动推进的时钟
+func newManualWheel(size, levels int) (*TimerWheel, *FakeClock) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	option := DefaultTimerWheelOption()
+	option.Tick = time.Hour
+	option.WheelSize = size
+	option.Levels = levels
+	// 回调在 Advance 中直接执行
+	option.Workers = 0
+	option.Clock = clock
+	return NewTimerWheelWithOption(option), clock
+}
+
+func TestTimerWheelLevels(t *testing.T) {
+	for _, levels := range []int{1, 3} {
+		w, clock := newManualWheel(4, levels)
+		fired := make(map[int][]uint64)
+		for i := 1; i <= 200; i++ {
+			i := i
+			w.AddTask(time.Duration(i)*time.Hour, 1, NewTimerTaskTimeOut(nil, func(interface{}) {
+				fired[i] = append(fired[i], w.current)
+			}))
+		}
+		repeat := w.AddTask(5*time.Hour, 3, NewTimerTaskTimeOut(nil, func(interface{}) {
+			fired[-1] = append(fired[-1], w.current)
+		}))
+		cancel := w.AddTask(10*time.Hour, 1, NewTimerTaskTimeOut(nil, func(interface{}) {
+			t.Errorf("canceled timer fired")
+		}))
+		w.CancelTimer(cancel)
+
+		clock.Advance(220 * time.Hour)
+		for i := 1; i <= 200; i++ {
+			if len(fired[i]) != 1 || fired[i][0] != uint64(i) {
+				t.Fatalf("levels %d: timer %d fired at %v", levels, i, fired[i])
+			}
+		}
+		if got := fired[-1]; len(got) != 3 || got[0] != 5 || got[1] != 10 || got[2] != 15 {
+			t.Fatalf("levels %d: repeat timer fired at %v", levels, got)
+		}
+		if w.Size() != 0 {
+			t.Fatalf("levels %d: expected empty wheel, got %d", levels, w.Size())
+		}
+		w.CancelTimer(repeat)
+		w.Stop()
+	}
+}
+
+func TestTimerWheelStop(t *testing.T) {
+	w := NewTimerWheel()
+	fired := make(chan bool, 2)
+	w.AddTask(10*time.Millisecond, 1, NewTimerTaskTimeOut(nil, func(interface{}) {
+		fired <- true
+	}))
+	w.AddTask(time.Hour, 1, NewTimerTaskTimeOut(nil, func(interface{}) {
+		fired <- true
+	}))
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired")
+	}
+	// 停止的时候剩下的执行一次
+	w.Stop()
+	select {
+	case <-fired:
+	default:
+		t.Fatal("pending timer not flushed")
+	}
+	if id := w.AddTask(time.Second, 1, NewTimerTaskTimeOut(nil, func(interface{}) {})); id != -1 {
+		t.Fatalf("expected -1 after stop, got %d", id)
+	}
+}
+
+func TestTimerWheelCallbackErrors(t *testing.T) {
+	option := DefaultTimerWheelOption()
+	option.Tick = time.Millisecond
+	errChan := make(chan error, 2)
+	option.OnError = func(id int64, err error) {
+		errChan <- err
+	}
+	w := NewTimerWheelWithOption(option)
+	defer w.Stop()
+
+	// 慢的回调不会耽误其他的定时器
+	block := make(chan bool)
+	defer close(block)
+	w.AddTask(time.Millisecond, 1, NewTimerTaskTimeOut(nil, func(interface{}) {
+		<-block
+	}))
+	w.AddTask(2*time.Millisecond, 1, NewTimerTaskTimeOut(nil, func(interface{}) {
+		panic("boom")
+	}))
+	failed := errors.New("failed")
+	own := make(chan error, 1)
+	w.AddTask(3*time.Millisecond, 1, NewTimerTaskTimeOutWithError("val", func(val interface{}) error {
+		return failed
+	}, func(val interface{}, err error) {
+		if val == "val" {
+			own <- err
+		}
+	}))
+
+	select {
+	case err := <-errChan:
+		if e, ok := err.(*PanicError); !ok || e.Value != "boom" || len(e.Stack) == 0 {
+			t.Fatalf("expected panic error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+	select {
+	case err := <-own:
+		if err != failed {
+			t.Fatalf("expected failed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error not reported")
+	}
+	if len(errChan) != 0 {
+		t.Fatal("timer error reported to wheel")
+	}
+}
+
+// 一次到期的定时器比队列多的时候，堆的版本以前会卡死
+func TestHeapWheelManyExpired(t *testing.T) {
+	w := NewHeapWheel()
+	done := make(chan bool, bufferSize*2)
+	for i := 0; i < bufferSize*2; i++ {
+		w.AddTask(time.Millisecond, 1, NewTimerTaskTimeOut(nil, func(interface{}) {
+			done <- true
+		}))
+	}
+	for i := 0; i < bufferSize*2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d callbacks run", i)
+		}
+	}
+	if n := w.Size(); n != 0 {
+		t.Fatalf("expected empty heap, got %d", n)
+	}
+}
+
+type benchWheel interface {
+	AddTask(interval time.Duration, count int64, to *TimerTaskTimeOut) int64
+	CancelTimer(id int64)
+	Size() int
+}
+
+// 已经有 existing 个定时器的时候，添加然后取消一个
+func benchAddCancel(b *testing.B, w benchWheel, existing int) {
+	to := NewTimerTaskTimeOut(nil, func(interface{}) {})
+	for i := 0; i < existing; i++ {
+		w.AddTask(time.Hour+time.Duration(i)*time.Millisecond, 1, to)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.CancelTimer(w.AddTask(30*time.Minute, 1, to))
+	}
+	// 等待异步的取消处理完
+	w.Size()
+}
+
+func BenchmarkTimerWheelAddCancel1K(b *testing.B) {
+	w := NewTimerWheel()
+	defer w.Stop()
+	benchAddCancel(b, w, 1000)
+}
+
+func BenchmarkTimerWheelAddCancel100K(b *testing.B) {
+	w := NewTimerWheel()
+	defer w.Stop()
+	benchAddCancel(b, w, 100000)
+}
+
+func BenchmarkHeapWheelAddCancel1K(b *testing.B) {
+	benchAddCancel(b, NewHeapWheel(), 1000)
+}
+
+func BenchmarkHeapWheelAddCancel100K(b *testing.B) {
+	benchAddCancel(b, NewHeapWheel(), 100000)
+}
+
+// 一百万个session的超时
+func BenchmarkTimerWheelAdd1M(b *testing.B) {
+	to := NewTimerTaskTimeOut(nil, func(interface{}) {})
+	for n := 0; n < b.N; n++ {
+		w := NewTimerWheel()
+		for i := 0; i < 1000000; i++ {
+			w.AddTask(time.Minute+time.Duration(i)*time.Microsecond, 1, to)
+		}
+		b.StopTimer()
+		w.Stop()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkHeapWheelAdd1M(b *testing.B) {
+	to := NewTimerTaskTimeOut(nil, func(interface{}) {})
+	for n := 0; n < b.N; n++ {
+		w := NewHeapWheel()
+		for i := 0; i < 1000000; i++ {
+			w.AddTask(time.Minute+time.Duration(i)*time.Microsecond, 1, to)
+		}
+		// 堆的版本 Stop 有计数的问题，这里只等添加处理完
+		w.Size()
+	}
+}
@@ -0,0 +1,76 @@
+package libtime
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// 回调panic的时候报告的错误
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("libtime: callback panic: %v", e.Value)
+}
+
+// 执行回调，panic转换成 *PanicError
+func safeCall(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+// 执行到期回调的协程池，队列满的时候提交会阻塞，不会丢掉回调
+// workers 为0的时候在提交的协程中直接执行
+type workerPool struct {
+	taskChan  chan func()
+	waitGroup sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := new(workerPool)
+	if workers <= 0 {
+		return p
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p.taskChan = make(chan func(), queueSize)
+	p.waitGroup.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.waitGroup.Done()
+	for fn := range p.taskChan {
+		fn()
+	}
+}
+
+func (p *workerPool) submit(fn func()) {
+	if p.taskChan == nil {
+		fn()
+		return
+	}
+	p.taskChan <- fn
+}
+
+// 关闭并且等待队列中的回调执行完，之后不能再提交
+func (p *workerPool) close() {
+	p.closeOnce.Do(func() {
+		if p.taskChan != nil {
+			close(p.taskChan)
+		}
+	})
+	p.waitGroup.Wait()
+}
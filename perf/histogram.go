@@ -0,0 +1,78 @@
+package perf
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/wuqifei/server_lib/concurrent"
+)
+
+// 默认的延迟分布，秒
+var DefaultLatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// 直方图，实现 expvar.Var，可以通过 MonitorOn 的 /debug/vars 查看
+// 每个桶记录小于等于上限的次数，超过最大上限的记在 +Inf 中
+// 记录的时候只有原子操作，读取的时候各个值不保证是同一时刻的
+type Histogram struct {
+	bounds []float64
+	counts []concurrent.AtomicInt64
+	count  concurrent.AtomicInt64
+	sum    expvar.Float
+}
+
+// 新建直方图，bounds为桶的上限，为空的时候使用 DefaultLatencyBuckets
+func NewHistogram(bounds []float64) *Histogram {
+	h := new(Histogram)
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	h.bounds = make([]float64, len(bounds))
+	copy(h.bounds, bounds)
+	sort.Float64s(h.bounds)
+	h.counts = make([]concurrent.AtomicInt64, len(h.bounds)+1)
+	return h
+}
+
+// 新建直方图并且用name发布到expvar
+func PublishHistogram(name string, bounds []float64) *Histogram {
+	h := NewHistogram(bounds)
+	expvar.Publish(name, h)
+	return h
+}
+
+// 记录一次
+func (h *Histogram) Observe(val float64) {
+	i := sort.SearchFloat64s(h.bounds, val)
+	h.counts[i].IncrementAndGet()
+	h.count.IncrementAndGet()
+	h.sum.Add(val)
+}
+
+// 记录的次数和总和
+func (h *Histogram) Count() (int64, float64) {
+	return h.count.Get(), h.sum.Value()
+}
+
+// json格式，桶的次数是累计的
+func (h *Histogram) String() string {
+	count, sum := h.Count()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"count": %d, "sum": %s, "buckets": {`, count, strconv.FormatFloat(sum, 'g', -1, 64))
+	var total int64
+	for i := range h.counts {
+		total += h.counts[i].Get()
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if i < len(h.bounds) {
+			fmt.Fprintf(&buf, `"%s": %d`, strconv.FormatFloat(h.bounds[i], 'g', -1, 64), total)
+		} else {
+			fmt.Fprintf(&buf, `"+Inf": %d`, total)
+		}
+	}
+	buf.WriteString("}}")
+	return buf.String()
+}
@@ -4,21 +4,20 @@ import (
 	"expvar"
 	"fmt"
 	"net/http"
-	"strconv"
 )
 
 var (
 	handleExported *expvar.Int
 	connExported   *expvar.Int
 	timeExported   *expvar.Float
-	qpsExported    *expvar.Float
 )
 
 func init() {
 	handleExported = expvar.NewInt("TotalHandle")
 	connExported = expvar.NewInt("TotalConn")
 	timeExported = expvar.NewFloat("TotalTime")
-	qpsExported = expvar.NewFloat("QPS")
+	// 读取的时候再计算，记录的时候只做原子加
+	expvar.Publish("QPS", expvar.Func(calculateQPS))
 }
 
 // MonitorOn starts up an HTTP monitor on port.
@@ -31,43 +30,34 @@ func MonitorOn(port int) {
 	}()
 }
 
-func addTotalConn(delta int64) {
+// 连接数的变化，建立的时候传1，关闭的时候传-1
+func AddTotalConn(delta int64) {
 	connExported.Add(delta)
-	calculateQPS()
 }
 
-func addTotalHandle() {
+// 处理了一次请求
+func AddTotalHandle() {
 	handleExported.Add(1)
-	calculateQPS()
 }
 
-func addTotalTime(seconds float64) {
+// 处理请求花费的时间，秒
+func AddTotalTime(seconds float64) {
 	timeExported.Add(seconds)
-	calculateQPS()
 }
 
-func calculateQPS() {
-	totalConn, err := strconv.ParseInt(connExported.String(), 10, 64)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	totalTime, err := strconv.ParseFloat(timeExported.String(), 64)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	totalHandle, err := strconv.ParseInt(handleExported.String(), 10, 64)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+// 处理了一次请求，以及花费的时间，秒
+func AddHandleTime(seconds float64) {
+	handleExported.Add(1)
+	timeExported.Add(seconds)
+}
 
-	if float64(totalConn)*totalTime != 0 {
-		// take the average time per worker go-routine
-		qps := float64(totalHandle) / (float64(totalConn) * (totalTime / float64(1)))
-		qpsExported.Set(qps)
+func calculateQPS() interface{} {
+	totalConn := connExported.Value()
+	totalTime := timeExported.Value()
+	totalHandle := handleExported.Value()
+	if float64(totalConn)*totalTime == 0 {
+		return float64(0)
 	}
+	// take the average time per worker go-routine
+	return float64(totalHandle) / (float64(totalConn) * totalTime)
 }
@@ -0,0 +1,39 @@
+package signal
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	restartMutex sync.Mutex
+	restartHooks []func() error
+)
+
+// OnRestart register a hot restart hook, it runs when the process gets SIGUSR2.
+// 钩子按注册的顺序执行，一般是启动新的进程，然后优雅关闭当前进程的服务
+// 全部成功之后 InitSignal 返回，和收到退出信号一样
+func OnRestart(hook func() error) {
+	restartMutex.Lock()
+	defer restartMutex.Unlock()
+	restartHooks = append(restartHooks, hook)
+}
+
+// 执行热重启的钩子，没有注册或者有失败的时候返回false，继续运行
+func restart() bool {
+	restartMutex.Lock()
+	hooks := make([]func() error, len(restartHooks))
+	copy(hooks, restartHooks)
+	restartMutex.Unlock()
+
+	if len(hooks) == 0 {
+		return false
+	}
+	for _, hook := range hooks {
+		if err := hook(); err != nil {
+			fmt.Printf("[Emergency]server restart error %v\n", err)
+			return false
+		}
+	}
+	return true
+}
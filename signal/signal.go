@@ -1,3 +1,5 @@
+// +build !windows
+
 package signal
 
 import (
@@ -10,7 +12,7 @@ import (
 // InitSignal register signals handler.
 func InitSignal() {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGSTOP, syscall.SIGINT, syscall.SIGHUP)
+	signal.Notify(c, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGSTOP, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR2)
 	defer close(c)
 	for {
 		s := <-c
@@ -20,6 +22,12 @@ func InitSignal() {
 			return
 		case syscall.SIGHUP:
 			continue
+		case syscall.SIGUSR2:
+			// 热重启，新的进程启动之后退出
+			if restart() {
+				return
+			}
+			continue
 		default:
 			return
 		}
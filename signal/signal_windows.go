@@ -10,5 +10,5 @@ func InitSignal() {
 	chanSig := make(chan os.Signal, 1)
 	defer close(chanSig)
 	signal.Notify(chanSig, os.Interrupt, os.Kill)
-	sig := <-chanSig
+	<-chanSig
 }